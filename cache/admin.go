@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const defaultAdminPath = "/_admin/"
+
+// AdminHandler 提供查看和管理节点的 JSON 接口：
+//
+//	GET    <base>groups                  所有 Group 的缓存大小和统计数据
+//	GET    <base>groups/<group>          单个 Group
+//	DELETE <base>groups/<group>          清空 Group 在本机的缓存
+//	DELETE <base>groups/<group>/<key>    删除本机缓存中的 key
+//	GET    <base>ring                    哈希环版本、每个节点的权重、虚拟节点数和哈希空间比例
+//	GET    <base>peers                   节点健康状态和节点列表文件的加载状态
+//	GET    <base>owner?key=<key>         key 的 owner 列表
+//
+// Token 不为空时请求需要带上 Authorization: Bearer <token>
+type AdminHandler struct {
+	pool     *HTTPPool
+	basePath string
+	token    string
+}
+
+// NewAdminHandler 为 pool 创建 AdminHandler，basePath 为空时使用 "/_admin/"
+func NewAdminHandler(pool *HTTPPool, basePath, token string) *AdminHandler {
+	if basePath == "" {
+		basePath = defaultAdminPath
+	}
+	if !strings.HasSuffix(basePath, "/") {
+		basePath += "/"
+	}
+	return &AdminHandler{pool: pool, basePath: basePath, token: token}
+}
+
+// GroupInfo 是 Group 在本机的缓存状态
+type GroupInfo struct {
+	Name      string           `json:"name"`
+	MainItems int              `json:"mainItems"`
+	MainBytes int64            `json:"mainBytes"`
+	HotItems  int              `json:"hotItems"`
+	HotBytes  int64            `json:"hotBytes"`
+	Stats     map[string]int64 `json:"stats"`
+}
+
+// RingPeer 是哈希环上的一个节点
+type RingPeer struct {
+	Peer         string  `json:"peer"`
+	Weight       int     `json:"weight"`
+	VirtualNodes int     `json:"virtualNodes"`
+	Share        float64 `json:"share"` // 负责的哈希空间比例
+	Self         bool    `json:"self"`
+}
+
+// RingInfo 是本机视角的哈希环
+type RingInfo struct {
+	Version    string     `json:"version"`
+	Mismatches int64      `json:"mismatches"` // 与其他节点交换版本时发现不一致的次数
+	Peers      []RingPeer `json:"peers"`
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, h.basePath) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if h.token != "" {
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+h.token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+	}
+	parts := strings.SplitN(r.URL.Path[len(h.basePath):], "/", 3)
+	switch {
+	case parts[0] == "groups" && len(parts) == 1 && r.Method == http.MethodGet:
+		h.listGroups(w)
+	case parts[0] == "groups" && len(parts) == 2:
+		h.group(w, r, parts[1])
+	case parts[0] == "groups" && len(parts) == 3 && r.Method == http.MethodDelete:
+		h.removeKey(w, parts[1], parts[2])
+	case parts[0] == "ring" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, h.pool.ringInfo())
+	case parts[0] == "peers" && r.Method == http.MethodGet:
+		h.peers(w)
+	case parts[0] == "owner" && r.Method == http.MethodGet:
+		h.owner(w, r.URL.Query().Get("key"))
+	default:
+		writeJSONError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *AdminHandler) listGroups(w http.ResponseWriter) {
+	registry := h.pool.opts.Registry
+	groups := make([]GroupInfo, 0)
+	for _, name := range registry.ListGroups() {
+		if g := registry.GetGroup(name); g != nil {
+			groups = append(groups, g.info())
+		}
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
+func (h *AdminHandler) group(w http.ResponseWriter, r *http.Request, name string) {
+	g := h.pool.opts.Registry.GetGroup(name)
+	if g == nil {
+		writeJSONError(w, http.StatusNotFound, "no such group: "+name)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, g.info())
+	case http.MethodDelete:
+		g.purgeLocally()
+		h.pool.Log("admin: purged group %s", name)
+		writeJSON(w, http.StatusOK, g.info())
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *AdminHandler) removeKey(w http.ResponseWriter, name, key string) {
+	g := h.pool.opts.Registry.GetGroup(name)
+	if g == nil {
+		writeJSONError(w, http.StatusNotFound, "no such group: "+name)
+		return
+	}
+	g.removeLocally(key)
+	h.pool.Log("admin: removed key %s of group %s", key, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) peers(w http.ResponseWriter) {
+	out := struct {
+		Peers     map[string]PeerStatus `json:"peers"`
+		PeersFile *PeersFileStatus      `json:"peersFile,omitempty"`
+	}{Peers: h.pool.PeerStatuses()}
+	if f := h.pool.PeersFile(); f != nil {
+		status := f.Status()
+		out.PeersFile = &status
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *AdminHandler) owner(w http.ResponseWriter, key string) {
+	if key == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing key")
+		return
+	}
+	p := h.pool
+	p.mu.Lock()
+	var owners []string
+	if p.peers != nil {
+		owners = p.peers.GetN(key, p.opts.ReplicationFactor)
+	}
+	p.mu.Unlock()
+	picked := p.self
+	if peer, ok := p.PickPeer(key); ok {
+		picked = peer.(*httpGetter).peer
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":    key,
+		"owners": owners, // 哈希环上的 owner，主节点在前
+		"picked": picked, // 考虑节点健康状态后实际会访问的节点
+	})
+}
+
+func (g *Group) info() GroupInfo {
+	info := GroupInfo{Name: g.name, Stats: g.stats.Snapshot()}
+	info.MainItems, info.MainBytes = g.mainCache.usage()
+	info.HotItems, info.HotBytes = g.hotCache.usage()
+	return info
+}
+
+func (p *HTTPPool) ringInfo() RingInfo {
+	info := RingInfo{Mismatches: p.RingMismatches(), Peers: make([]RingPeer, 0)}
+	info.Version = p.ring.header()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return info
+	}
+	vnodes, shares := p.peers.Shares()
+	for peer, weight := range p.weights {
+		info.Peers = append(info.Peers, RingPeer{
+			Peer:         peer,
+			Weight:       weight,
+			VirtualNodes: vnodes[peer],
+			Share:        shares[peer],
+			Self:         peer == p.self,
+		})
+	}
+	sort.Slice(info.Peers, func(i, j int) bool {
+		return info.Peers[i].Peer < info.Peers[j].Peer
+	})
+	return info
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func adminDo(t *testing.T, h http.Handler, method, path, token string, out interface{}) int {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAdminHandler(t *testing.T) {
+	nodes := newTestCluster(t, 2, HTTPPoolOptions{})
+	a := nodes[0]
+	h := NewAdminHandler(a.pool, "", "secret")
+	keys := []string{"k1", "k2", "k3"}
+	for i := 0; len(keys) < 4; i++ { //至少有一个 key 由本机加载，保证本机缓存不为空
+		if _, ok := a.pool.PickPeer(fmt.Sprint("local", i)); !ok {
+			keys = append(keys, fmt.Sprint("local", i))
+		}
+	}
+	for _, key := range keys {
+		if _, err := a.group.Get(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if code := adminDo(t, h, "GET", "/_admin/groups", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("request without token = %d", code)
+	}
+	if code := adminDo(t, h, "GET", "/_admin/groups", "wrong", nil); code != http.StatusUnauthorized {
+		t.Fatalf("request with wrong token = %d", code)
+	}
+
+	var groups []GroupInfo
+	if code := adminDo(t, h, "GET", "/_admin/groups", "secret", &groups); code != http.StatusOK {
+		t.Fatalf("GET groups = %d", code)
+	}
+	if len(groups) != 1 || groups[0].Name != "group" || groups[0].Stats["Gets"] != 4 {
+		t.Fatalf("groups = %+v", groups)
+	}
+	if groups[0].MainItems+groups[0].HotItems == 0 {
+		t.Fatal("expect some cached items")
+	}
+
+	var ring RingInfo
+	adminDo(t, h, "GET", "/_admin/ring", "secret", &ring)
+	if len(ring.Peers) != 2 || ring.Version == "" {
+		t.Fatalf("ring = %+v", ring)
+	}
+	var total float64
+	for _, peer := range ring.Peers {
+		if peer.VirtualNodes == 0 || peer.Self != (peer.Peer == a.url()) {
+			t.Fatalf("ring peer = %+v", peer)
+		}
+		total += peer.Share
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Fatalf("shares sum to %v", total)
+	}
+
+	var owner struct {
+		Owners []string
+		Picked string
+	}
+	adminDo(t, h, "GET", "/_admin/owner?key=k1", "secret", &owner)
+	if len(owner.Owners) != 1 || owner.Picked != owner.Owners[0] {
+		t.Fatalf("owner = %+v", owner)
+	}
+	if code := adminDo(t, h, "GET", "/_admin/owner", "secret", nil); code != http.StatusBadRequest {
+		t.Fatalf("owner without key = %d", code)
+	}
+
+	var peers struct {
+		Peers map[string]PeerStatus
+	}
+	adminDo(t, h, "GET", "/_admin/peers", "secret", &peers)
+	if _, ok := peers.Peers[nodes[1].url()]; !ok {
+		t.Fatalf("peers = %+v", peers)
+	}
+
+	var info GroupInfo
+	if code := adminDo(t, h, "DELETE", "/_admin/groups/group", "secret", &info); code != http.StatusOK {
+		t.Fatalf("purge = %d", code)
+	}
+	if info.MainItems != 0 || info.HotItems != 0 {
+		t.Fatalf("group should be empty after purge, %+v", info)
+	}
+	if code := adminDo(t, h, "DELETE", "/_admin/groups/group/k1", "secret", nil); code != http.StatusNoContent {
+		t.Fatalf("remove key = %d", code)
+	}
+	if code := adminDo(t, h, "GET", "/_admin/groups/nope", "secret", nil); code != http.StatusNotFound {
+		t.Fatalf("unknown group = %d", code)
+	}
+}
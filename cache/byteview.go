@@ -1,7 +1,16 @@
 package cache
 
+import (
+	"bytes"
+	pb "go-tools/gocachepb"
+	"io"
+	"time"
+)
+
 type ByteView struct {
-	b []byte
+	b  []byte
+	e  time.Time //过期时间，零值表示永不过期
+	ct string    //通过 REST API 写入时的 Content-Type，为空表示未知
 }
 
 func (v ByteView) Len() int {
@@ -16,8 +25,62 @@ func (v ByteView) ByteSlice() []byte {
 	return cloneBytes(v.b)
 }
 
+// same 判断 v 和 o 是否是同一个值：引用同一份数据并且过期时间、Content-Type 相同
+func (v ByteView) same(o ByteView) bool {
+	if len(v.b) != len(o.b) || !v.e.Equal(o.e) || v.ct != o.ct {
+		return false
+	}
+	return len(v.b) == 0 || &v.b[0] == &o.b[0]
+}
+
+// Reader 返回读取该值的 io.Reader，不复制数据
+func (v ByteView) Reader() io.Reader {
+	return bytes.NewReader(v.b)
+}
+
+// Expire 返回该值的过期时间，零值表示永不过期
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+// ContentType 返回写入该值时的 Content-Type，未知时为空
+func (v ByteView) ContentType() string {
+	return v.ct
+}
+
+func (v ByteView) expired(now time.Time) bool {
+	return !v.e.IsZero() && !now.Before(v.e)
+}
+
 func cloneBytes(b []byte) []byte {
 	c := make([]byte, len(b))
 	copy(c, b)
 	return c
 }
+
+// response 把值编码为节点之间传输的 pb.Response
+func (v ByteView) response() *pb.Response {
+	res := &pb.Response{Value: v.ByteSlice(), ContentType: v.ct}
+	if !v.e.IsZero() {
+		res.Expire = v.e.UnixNano()
+	}
+	return res
+}
+
+// setRequest 把值编码为推送给其他节点的 pb.SetRequest
+func (v ByteView) setRequest(group, key string) *pb.SetRequest {
+	req := &pb.SetRequest{Group: group, Key: key, Value: v.b, ContentType: v.ct}
+	if !v.e.IsZero() {
+		req.Expire = v.e.UnixNano()
+	}
+	return req
+}
+
+// viewOf 从节点之间传输的值、过期时间和 Content-Type 构造 ByteView
+func viewOf(b []byte, expire int64, ct string) ByteView {
+	v := ByteView{b: b, ct: ct}
+	if expire != 0 {
+		v.e = time.Unix(0, expire)
+	}
+	return v
+}
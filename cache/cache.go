@@ -3,32 +3,120 @@ package cache
 import (
 	"go-tools/lru"
 	"sync"
+	"time"
 )
 
+// Evictor 是 cache 底层的存储与淘汰策略，*lru.Cache 实现了该接口
+type Evictor interface {
+	Add(key string, value lru.Value)
+	Get(key string) (lru.Value, bool)
+	Remove(key string)
+	Range(fn func(key string, value lru.Value) bool) //从最先被淘汰的记录开始遍历
+	Len() int
+}
+
+// EvictionPolicy 按最大字节数创建 Evictor，onEvicted 需在记录被淘汰或删除时调用
+type EvictionPolicy func(maxBytes int64, onEvicted func(key string, value lru.Value)) Evictor
+
+// LRUPolicy 是默认的淘汰策略
+func LRUPolicy(maxBytes int64, onEvicted func(key string, value lru.Value)) Evictor {
+	return lru.New(maxBytes, onEvicted)
+}
+
 type cache struct {
 	mu         sync.Mutex
-	lru        *lru.Cache
+	lru        Evictor
+	policy     EvictionPolicy //为 nil 时使用 LRUPolicy
 	cacheBytes int64
 }
 
-func (c cache) add(key string, value ByteView) {
+func (c *cache) add(key string, value ByteView) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+		policy := c.policy
+		if policy == nil {
+			policy = LRUPolicy
+		}
+		c.lru = policy(c.cacheBytes, nil)
 	}
 	c.lru.Add(key, value)
 }
-
-func (c cache) get(key string) (ByteView, bool) {
+func (c *cache) get(key string) (ByteView, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
 		return ByteView{}, false
 	}
 	if v, ok := c.lru.Get(key); ok {
+		if v.(ByteView).expired(time.Now()) { //过期的值直接删除 当作未命中
+			c.lru.Remove(key)
+			return ByteView{}, false
+		}
 		return v.(ByteView), ok
 	}
 
 	return ByteView{}, false
 }
+
+// replace 在 key 当前的值仍是 old 时替换为 value，值已经被并发更新或删除时不做修改并返回 false
+func (c *cache) replace(key string, old, value ByteView) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return false
+	}
+	cur, ok := c.lru.Get(key)
+	if !ok || !cur.(ByteView).same(old) {
+		return false
+	}
+	c.lru.Add(key, value)
+	return true
+}
+
+// entries 按从旧到新的访问顺序返回所有未过期的记录
+func (c *cache) entries() (keys []string, values []ByteView) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil, nil
+	}
+	now := time.Now()
+	c.lru.Range(func(key string, value lru.Value) bool {
+		if v := value.(ByteView); !v.expired(now) {
+			keys = append(keys, key)
+			values = append(values, v)
+		}
+		return true
+	})
+	return keys, values
+}
+
+func (c *cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru != nil {
+		c.lru.Remove(key)
+	}
+}
+
+// usage 返回缓存的记录数和占用的字节数（key 与 value 的长度之和）
+func (c *cache) usage() (items int, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0, 0
+	}
+	c.lru.Range(func(key string, value lru.Value) bool {
+		bytes += int64(len(key) + value.Len())
+		return true
+	})
+	return c.lru.Len(), bytes
+}
+
+// clear 释放所有缓存数据
+func (c *cache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru = nil
+}
@@ -0,0 +1,288 @@
+// Package client 是 gocache 集群的 Go 客户端，不需要在本地创建 cache.Group。
+// Client 使用与节点相同的一致性哈希环，直接通过节点之间的 HTTP 协议访问 key 的 owner
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-tools/cache"
+	"go-tools/consistenthash"
+	pb "go-tools/gocachepb"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBasePath     = "/_gocache/"
+	defaultReplicas     = 50
+	defaultTimeout      = 5 * time.Second
+	defaultMaxIdleConns = 16
+)
+
+// ErrNoPeers 表示 Client 还没有设置节点列表
+var ErrNoPeers = errors.New("gocache client: no peers")
+
+// Options 是 Client 的可选配置，零值字段使用默认值。
+// BasePath、Replicas、HashFn 和 ReplicationFactor 需要与集群的 HTTPPoolOptions 一致，否则会访问到错误的节点
+type Options struct {
+	// BasePath 节点间通信的路径前缀，默认 "/_gocache/"
+	BasePath string
+	// Replicas 一致性哈希中每个节点的虚拟节点数，默认 50
+	Replicas int
+	// HashFn 一致性哈希函数，默认 crc32.ChecksumIEEE
+	HashFn consistenthash.Hash
+	// ReplicationFactor 每个 key 的 owner 数量，Get 依次尝试各个 owner，Set 和 Delete 写入所有 owner，默认 1
+	ReplicationFactor int
+
+	// Transport 访问节点使用的 http.RoundTripper，默认是按 MaxIdleConnsPerHost 配置的 http.Transport
+	Transport http.RoundTripper
+	// Timeout 单次请求的超时时间，默认 5s，小于 0 表示不超时
+	Timeout time.Duration
+	// MaxIdleConnsPerHost 每个节点保留的空闲连接数，默认 16，只在 Transport 为 nil 时生效
+	MaxIdleConnsPerHost int
+	// MaxRetries 请求在连接错误、超时或 502/504 时对同一个节点的最大重试次数，默认 0 不重试，最多 10 次
+	MaxRetries int
+	// RetryBackoff 重试的基础等待时间，第 n 次重试随机等待 [0, RetryBackoff*2^(n-1))，区间上限最多增长到 5s，默认 50ms
+	RetryBackoff time.Duration
+
+	// TLS 不为 nil 时使用它的证书访问开启了 mutual TLS 的集群，只在 Transport 为 nil 时生效
+	TLS cache.CertProvider
+	// SigningSecret 不为空时用它对请求签名，对应集群 HTTPPoolOptions.SigningSecrets 中的第一个密钥
+	SigningSecret []byte
+}
+
+// Client 可以被多个 goroutine 同时使用，所有节点共用一个连接池
+type Client struct {
+	opts   Options
+	client *http.Client
+	retry  cache.Retry
+
+	mu      sync.RWMutex
+	ring    *consistenthash.Map
+	weights map[string]int
+}
+
+// New 创建访问 peers 的 Client，o 为 nil 时使用默认配置
+func New(peers []string, o *Options) *Client {
+	c := &Client{}
+	if o != nil {
+		c.opts = *o
+	}
+	if c.opts.BasePath == "" {
+		c.opts.BasePath = defaultBasePath
+	}
+	if c.opts.Replicas == 0 {
+		c.opts.Replicas = defaultReplicas
+	}
+	if c.opts.ReplicationFactor < 1 {
+		c.opts.ReplicationFactor = 1
+	}
+	if c.opts.Timeout == 0 {
+		c.opts.Timeout = defaultTimeout
+	}
+	if c.opts.MaxIdleConnsPerHost == 0 {
+		c.opts.MaxIdleConnsPerHost = defaultMaxIdleConns
+	}
+	c.retry = cache.NewRetry(c.opts.MaxRetries, c.opts.RetryBackoff)
+	c.opts.MaxRetries, c.opts.RetryBackoff = c.retry.MaxRetries, c.retry.Backoff
+	transport := c.opts.Transport
+	if transport == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxIdleConnsPerHost = c.opts.MaxIdleConnsPerHost
+		transport = t
+		if c.opts.TLS != nil {
+			transport = cache.TLSTransport(t, c.opts.TLS)
+		}
+	}
+	c.client = &http.Client{Transport: transport}
+	if c.opts.Timeout > 0 {
+		c.client.Timeout = c.opts.Timeout
+	}
+	c.SetPeers(peers...)
+	return c
+}
+
+// SetPeers 更新节点列表，所有节点权重为 1
+func (c *Client) SetPeers(peers ...string) {
+	weights := make(map[string]int, len(peers))
+	for _, peer := range peers {
+		weights[peer] = 1
+	}
+	c.SetWeighted(weights)
+}
+
+// SetWeighted 更新节点列表及权重，与集群的 HTTPPool.SetWeighted 对应
+func (c *Client) SetWeighted(weights map[string]int) {
+	ring := consistenthash.New(c.opts.Replicas, c.opts.HashFn)
+	cp := make(map[string]int, len(weights))
+	for peer, w := range weights {
+		if w > 0 {
+			ring.AddWeighted(peer, w)
+			cp[peer] = w
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ring = ring
+	c.weights = cp
+}
+
+// Owners 返回 key 的 owner 列表，主节点在前
+func (c *Client) Owners(key string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.weights) == 0 {
+		return nil
+	}
+	return c.ring.GetN(key, c.opts.ReplicationFactor)
+}
+
+// Get 依次向 key 的各个 owner 读取，返回第一个成功的结果。key 不存在时返回的错误满足 errors.Is(err, cache.ErrNotFound)
+func (c *Client) Get(ctx context.Context, group, key string) ([]byte, error) {
+	owners := c.Owners(key)
+	if len(owners) == 0 {
+		return nil, ErrNoPeers
+	}
+	var err error
+	for _, peer := range owners {
+		out := &pb.Response{}
+		if err = c.do(ctx, peer, http.MethodGet, group, key, nil, out); err == nil {
+			return out.GetValue(), nil
+		}
+		if errors.Is(err, cache.ErrNotFound) || ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// GetMany 按 owner 分组并发读取 keys，返回读取成功的值和遇到的第一个错误，失败的 key 不在结果中
+func (c *Client) GetMany(ctx context.Context, group string, keys []string) (map[string][]byte, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		values   = make(map[string][]byte, len(keys))
+		firstErr error
+	)
+	byOwner := make(map[string][]string)
+	for _, key := range keys {
+		owners := c.Owners(key)
+		if len(owners) == 0 {
+			return nil, ErrNoPeers
+		}
+		byOwner[owners[0]] = append(byOwner[owners[0]], key)
+	}
+	for _, keys := range byOwner {
+		wg.Add(1)
+		go func(keys []string) { //同一个 owner 的 key 顺序读取，复用同一条连接
+			defer wg.Done()
+			for _, key := range keys {
+				value, err := c.Get(ctx, group, key)
+				mu.Lock()
+				if err == nil {
+					values[key] = value
+				} else if firstErr == nil {
+					firstErr = fmt.Errorf("get %s: %w", key, err)
+				}
+				mu.Unlock()
+			}
+		}(keys)
+	}
+	wg.Wait()
+	return values, firstErr
+}
+
+// Set 把 value 写入 key 的所有 owner 的缓存，ttl 为 0 表示永不过期
+func (c *Client) Set(ctx context.Context, group, key string, value []byte, ttl time.Duration) error {
+	req := &pb.SetRequest{Group: group, Key: key, Value: value}
+	if ttl > 0 {
+		req.Expire = time.Now().Add(ttl).UnixNano()
+	}
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return c.each(key, func(peer string) error {
+		return c.do(ctx, peer, http.MethodPut, group, key, body, &pb.SetResponse{})
+	})
+}
+
+// Delete 从 key 的所有 owner 的缓存中删除 key，之后的 Get 会重新从数据源加载
+func (c *Client) Delete(ctx context.Context, group, key string) error {
+	return c.each(key, func(peer string) error {
+		return c.do(ctx, peer, http.MethodDelete, group, key, nil, &pb.DeleteResponse{})
+	})
+}
+
+// Close 关闭空闲连接
+func (c *Client) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// each 对 key 的每个 owner 调用 fn，返回第一个错误
+func (c *Client) each(key string, fn func(peer string) error) error {
+	owners := c.Owners(key)
+	if len(owners) == 0 {
+		return ErrNoPeers
+	}
+	var firstErr error
+	for _, peer := range owners {
+		if err := fn(peer); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", peer, err)
+		}
+	}
+	return firstErr
+}
+
+// do 发送请求，在可重试的错误后按 MaxRetries 重试。三种请求都是幂等的，都可以重试
+func (c *Client) do(ctx context.Context, peer, method, group, key string, body []byte, out proto.Message) error {
+	u := fmt.Sprintf("%v%v%v/%v", peer, c.opts.BasePath, url.QueryEscape(group), url.QueryEscape(key))
+	return c.retry.Do(ctx, func() (bool, error) {
+		return c.try(ctx, method, u, body, out)
+	}, nil)
+}
+
+// try 发送一次请求，retry 表示失败的请求是否可以重试
+func (c *Client) try(ctx context.Context, method, u string, body []byte, out proto.Message) (retry bool, err error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return false, err
+	}
+	if len(c.opts.SigningSecret) > 0 {
+		if err := cache.SignRequest(req, body, c.opts.SigningSecret); err != nil {
+			return false, err
+		}
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer res.Body.Close()
+	payload, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return true, fmt.Errorf("reading response body: %v", err)
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return false, fmt.Errorf("%w: %s", cache.ErrNotFound, bytes.TrimSpace(payload))
+	case res.StatusCode != http.StatusOK:
+		err = fmt.Errorf("server returned %v: %s", res.Status, bytes.TrimSpace(payload))
+		return cache.RetryableStatus(res.StatusCode), err
+	}
+	if err := proto.Unmarshal(payload, out); err != nil {
+		return false, fmt.Errorf("decoding response body: %v", err)
+	}
+	return false, nil
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-tools/cache"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type node struct {
+	pool  *cache.HTTPPool
+	group *cache.Group
+	srv   *httptest.Server
+	loads int32
+}
+
+func startCluster(t *testing.T, n int, o cache.HTTPPoolOptions) ([]*node, []string) {
+	nodes := make([]*node, n)
+	addrs := make([]string, n)
+	for i := range nodes {
+		nd := &node{}
+		nd.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nd.pool.ServeHTTP(w, r)
+		}))
+		nodes[i], addrs[i] = nd, nd.srv.URL
+	}
+	for _, nd := range nodes {
+		nd := nd
+		r := cache.NewRegistry()
+		opts := o
+		opts.Registry = r
+		nd.pool = cache.NewHTTPPoolOpts(nd.srv.URL, &opts)
+		nd.pool.Set(addrs...)
+		nd.group, _ = r.NewGroupOpts("group", cache.GetterFunc(func(key string) ([]byte, error) {
+			if key == "missing" {
+				return nil, cache.ErrNotFound
+			}
+			atomic.AddInt32(&nd.loads, 1)
+			return []byte("v-" + key), nil
+		}), cache.WithPeerPicker(nd.pool))
+	}
+	t.Cleanup(func() {
+		for _, nd := range nodes {
+			nd.srv.Close()
+			nd.pool.Close()
+		}
+	})
+	return nodes, addrs
+}
+
+func TestClient(t *testing.T) {
+	nodes, addrs := startCluster(t, 3, cache.HTTPPoolOptions{})
+	c := New(addrs, nil)
+	defer c.Close()
+	ctx := context.Background()
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprint("k", i)
+	}
+	values, err := c.GetMany(ctx, "group", keys)
+	if err != nil || len(values) != len(keys) {
+		t.Fatalf("GetMany = %d values, %v", len(values), err)
+	}
+	var loads int32
+	for _, nd := range nodes {
+		loads += nd.loads
+		if nd.group.Stats().PeerLoads.Get() != 0 {
+			t.Fatal("client should send each key straight to its owner")
+		}
+	}
+	if loads != int32(len(keys)) {
+		t.Fatalf("loads = %d", loads)
+	}
+
+	if err := c.Set(ctx, "group", "k0", []byte("new"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "group", "k0"); err != nil || string(v) != "new" {
+		t.Fatalf("Get after Set = %q, %v", v, err)
+	}
+	if err := c.Delete(ctx, "group", "k0"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "group", "k0"); err != nil || string(v) != "v-k0" {
+		t.Fatalf("Get after Delete = %q, %v", v, err)
+	}
+
+	if _, err := c.Get(ctx, "group", "missing"); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("Get(missing) = %v, expect ErrNotFound", err)
+	}
+	if _, err := New(nil, nil).Get(ctx, "group", "k1"); err != ErrNoPeers {
+		t.Fatalf("Get without peers = %v", err)
+	}
+}
+
+func TestClientSigningAndRetry(t *testing.T) {
+	secret := []byte("secret")
+	_, addrs := startCluster(t, 1, cache.HTTPPoolOptions{SigningSecrets: [][]byte{secret}})
+	ctx := context.Background()
+	if _, err := New(addrs, nil).Get(ctx, "group", "k"); err == nil {
+		t.Fatal("unsigned request should be rejected")
+	}
+
+	// 代理在第一次请求时返回 502
+	var calls int32
+	target, _ := url.Parse(addrs[0])
+	rp := httputil.NewSingleHostReverseProxy(target)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		rp.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	c := New([]string{proxy.URL}, &Options{SigningSecret: secret, MaxRetries: 1, RetryBackoff: time.Millisecond})
+	if v, err := c.Get(ctx, "group", "k"); err != nil || string(v) != "v-k" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if calls != 2 {
+		t.Fatalf("expect one retry, calls = %d", calls)
+	}
+}
+
+func TestClientRetryLimit(t *testing.T) {
+	for retries, expect := range map[int]int{-1: 0, 3: 3, 1000: 10} {
+		if c := New(nil, &Options{MaxRetries: retries}); c.opts.MaxRetries != expect || c.retry.MaxRetries != expect {
+			t.Fatalf("MaxRetries %d = %d, expect %d", retries, c.opts.MaxRetries, expect)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 开启压缩后缓存中的值带一个字节的前缀，标识后面的数据是否被压缩
+const (
+	valueRaw        = 0
+	valueCompressed = 1
+)
+
+var ErrInvalidCompressed = errors.New("gocache: invalid compressed value")
+
+// Compressor 压缩缓存中的值
+type Compressor interface {
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+// GzipCompressor 是基于 compress/gzip 的 Compressor，Level 为 0 时使用 gzip.DefaultCompression
+type GzipCompressor struct {
+	Level int
+}
+
+func (c GzipCompressor) Compress(b []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c GzipCompressor) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// compress 在值不小于 minSize 且压缩后更小时返回压缩数据，否则原样保存
+func compress(c Compressor, minSize int, b []byte) ([]byte, error) {
+	if len(b) >= minSize {
+		z, err := c.Compress(b)
+		if err != nil {
+			return nil, err
+		}
+		if len(z) < len(b) {
+			return append([]byte{valueCompressed}, z...), nil
+		}
+	}
+	return append([]byte{valueRaw}, b...), nil
+}
+
+func decompress(c Compressor, b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, ErrInvalidCompressed
+	}
+	switch b[0] {
+	case valueRaw:
+		return b[1:], nil
+	case valueCompressed:
+		return c.Decompress(b[1:])
+	}
+	return nil, ErrInvalidCompressed
+}
+
+// gzipWriters 复用压缩节点之间响应的 gzip.Writer
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// gzipBytes 压缩节点之间的响应体
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gzipTo(&buf, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gzipTo 把 write 写出的数据压缩后写入 w
+func gzipTo(w io.Writer, write func(w io.Writer) error) error {
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(w)
+	if err := write(zw); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// incompressibleTypes 是本身已经压缩过的 Content-Type 前缀，再用 gzip 压缩只会变大
+var incompressibleTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "video/", "audio/",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd", "application/x-7z-compressed"}
+
+// streamCompressible 判断分块传输的值是否值得压缩：跳过已经压缩过的 Content-Type，
+// 其余的值用第一块试压缩，至少减少 1/8 时才压缩整个流
+func streamCompressible(view ByteView) bool {
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(view.ct, prefix) {
+			return false
+		}
+	}
+	sample := view.b
+	if len(sample) > streamChunkSize {
+		sample = sample[:streamChunkSize]
+	}
+	z, err := gzipBytes(sample)
+	return err == nil && len(z) <= len(sample)-len(sample)/8
+}
+
+// acceptsGzip 判断 Accept-Encoding 是否接受 gzip，q=0 表示不接受
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(coding) != "gzip" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		return q > 0
+	}
+	return false
+}
+
+// countingWriter 统计写出的字节数
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// gzipReadCloser 读取时解压，关闭时同时关闭底层的响应体
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 加密信封格式: | version(1) | keyID(4) | nonce(12) | ciphertext+tag |
+// 缓存 key 作为 AAD 参与认证，密文不能被挪到别的 key 下使用
+const (
+	envelopeVersion   = 1
+	envelopeHeaderLen = 1 + 4
+)
+
+var (
+	ErrUnknownKey      = errors.New("gocache: unknown encryption key")
+	ErrInvalidEnvelope = errors.New("gocache: invalid encrypted value")
+)
+
+// KeyProvider 为 Group 提供 AES key，支持轮换：
+// CurrentKey 返回当前用于加密的 key 及其 id，Key 按 id 取回旧 key 用于解密
+type KeyProvider interface {
+	CurrentKey() (id uint32, key []byte, err error)
+	Key(id uint32) ([]byte, error)
+}
+
+// KeyRing 是一个内存中的 KeyProvider，Rotate 切换当前 key，旧 key 保留用于解密直到 Retire
+type KeyRing struct {
+	mu      sync.RWMutex
+	current uint32
+	keys    map[uint32][]byte
+}
+
+func NewKeyRing(id uint32, key []byte) (*KeyRing, error) {
+	r := &KeyRing{keys: make(map[uint32][]byte)}
+	if err := r.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Rotate 添加一个新 key 并设为当前加密 key
+func (r *KeyRing) Rotate(id uint32, key []byte) error {
+	if err := checkKeyLen(key); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[id] = cloneBytes(key)
+	r.current = id
+	return nil
+}
+
+// Retire 删除一个旧 key，用它加密的缓存值之后会被视为未命中
+func (r *KeyRing) Retire(id uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id != r.current {
+		delete(r.keys, id)
+	}
+}
+
+func (r *KeyRing) CurrentKey() (uint32, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current, r.keys[r.current], nil
+}
+
+func (r *KeyRing) Key(id uint32) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if key, ok := r.keys[id]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func checkKeyLen(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+	return fmt.Errorf("gocache: invalid AES key size %d", len(key))
+}
+
+// envelope 负责缓存值的 AES-GCM 加解密，按 key id 缓存 AEAD 实例
+type envelope struct {
+	kp    KeyProvider
+	mu    sync.Mutex
+	aeads map[uint32]cipher.AEAD
+}
+
+func newEnvelope(kp KeyProvider) *envelope {
+	return &envelope{kp: kp, aeads: make(map[uint32]cipher.AEAD)}
+}
+
+func (e *envelope) aead(id uint32, key []byte) (cipher.AEAD, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if a, ok := e.aeads[id]; ok {
+		return a, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	a, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	e.aeads[id] = a
+	return a, nil
+}
+
+func (e *envelope) seal(key string, plain []byte) ([]byte, error) {
+	id, k, err := e.kp.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	a, err := e.aead(id, k)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, envelopeHeaderLen+a.NonceSize(), envelopeHeaderLen+a.NonceSize()+len(plain)+a.Overhead())
+	out[0] = envelopeVersion
+	binary.BigEndian.PutUint32(out[1:envelopeHeaderLen], id)
+	nonce := out[envelopeHeaderLen:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return a.Seal(out, nonce, plain, []byte(key)), nil
+}
+
+// open 解密缓存值，stale 表示该值不是用当前 key 加密的，调用方可以重新加密
+func (e *envelope) open(key string, sealed []byte) (plain []byte, stale bool, err error) {
+	if len(sealed) < envelopeHeaderLen || sealed[0] != envelopeVersion {
+		return nil, false, ErrInvalidEnvelope
+	}
+	id := binary.BigEndian.Uint32(sealed[1:envelopeHeaderLen])
+	// 每次都向 KeyProvider 确认 key 仍然有效，已 Retire 的 key 不能再解密
+	k, err := e.kp.Key(id)
+	if err != nil {
+		e.forget(id)
+		return nil, false, err
+	}
+	a, err := e.aead(id, k)
+	if err != nil {
+		return nil, false, err
+	}
+	body := sealed[envelopeHeaderLen:]
+	if len(body) < a.NonceSize() {
+		return nil, false, ErrInvalidEnvelope
+	}
+	plain, err = a.Open(nil, body[:a.NonceSize()], body[a.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, false, ErrInvalidEnvelope
+	}
+	current, _, err := e.kp.CurrentKey()
+	return plain, err == nil && current != id, nil
+}
+
+// forget 丢弃已经从 KeyProvider 中移除的 key 对应的 AEAD
+func (e *envelope) forget(id uint32) {
+	e.mu.Lock()
+	delete(e.aeads, id)
+	e.mu.Unlock()
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptedGroup(t *testing.T) {
+	ring, err := NewKeyRing(1, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, _ := NewRegistry().NewGroup("encrypted", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("secret-" + key), nil
+	}))
+	if err := g.RegisterKeyProvider(ring); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := g.Get("Tom"); err != nil || v.String() != "secret-Tom" {
+		t.Fatalf("Get Tom = %q, %v", v.String(), err)
+	}
+	raw, ok := g.mainCache.get("Tom")
+	if !ok || bytes.Contains(raw.b, []byte("secret-Tom")) {
+		t.Fatalf("cached value should be encrypted, got %q", raw.b)
+	}
+
+	// 轮换后旧值仍可读，并且被重新加密为新 key
+	if err := ring.Rotate(2, bytes.Repeat([]byte{2}, 16)); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := g.lookupCache("Tom"); !ok || v.String() != "secret-Tom" {
+		t.Fatalf("lookup after rotate = %q, %v", v.String(), ok)
+	}
+	ring.Retire(1)
+	if v, ok := g.lookupCache("Tom"); !ok || v.String() != "secret-Tom" {
+		t.Fatalf("value should have been re-encrypted with key 2, got %q, %v", v.String(), ok)
+	}
+
+	// 读取旧密文之后 key 被并发更新，重新加密不能覆盖新的值
+	if err := ring.Rotate(3, bytes.Repeat([]byte{3}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := g.mainCache.get("Tom")
+	g.set("Tom", ByteView{b: []byte("newer")})
+	if sealed, err := g.encode("Tom", ByteView{b: []byte("secret-Tom")}); err != nil || g.mainCache.replace("Tom", old, sealed) {
+		t.Fatalf("replace over a newer value = %v", err)
+	}
+	if v, ok := g.lookupCache("Tom"); !ok || v.String() != "newer" {
+		t.Fatalf("newer value should be kept, got %q, %v", v.String(), ok)
+	}
+
+	// 密文绑定了缓存 key，换到别的 key 下无法解密
+	raw, _ = g.mainCache.get("Tom")
+	g.mainCache.add("Jack", raw)
+	if _, ok := g.lookupCache("Jack"); ok {
+		t.Fatal("ciphertext moved to another key should not decrypt")
+	}
+}
+
+func TestKeyRingInvalidKey(t *testing.T) {
+	if _, err := NewKeyRing(1, []byte("short")); err == nil {
+		t.Fatal("expect error for invalid AES key size")
+	}
+}
@@ -1,12 +1,14 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	pb "go-tools/gocachepb"
-	"go-tools/lru"
 	"go-tools/singleflight"
-	"log"
+	"math/rand"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Getter interface {
@@ -24,112 +26,469 @@ type Group struct {
 	name      string
 	getter    Getter //缓存未命中时获取源数据的回调
 	mainCache cache
+	hotCache  cache //缓存部分从其他节点取回的热点数据，opts.hotCacheRatio 为 0 时不启用
 	peers     PeerPicker
 	loader    *singleflight.Group //fetch once
+	crypter   *envelope           //不为 nil 时缓存中存放的是加密后的值
+	limiter   *originLimiter      //不为 nil 时限制回源的并发和速率
+	hedger    *hedger             //不为 nil 时远程读取过慢会发出对冲请求
+	removed   int32               //被 Registry 移除后置为 1，之后的 Get 直接返回 ErrGroupRemoved
+	opts      groupOptions
+	logger    Logger
+	stats     *Stats
 }
 
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-)
-
+// NewGroup 在 DefaultRegistry 中创建 Group，同名 Group 已存在时 panic
 func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
-	if getter == nil {
-		panic("nil Getter")
+	g, err := DefaultRegistry.NewGroup(name, cacheBytes, getter)
+	if err != nil {
+		panic(err)
 	}
-	mu.Lock()
-	defer mu.Unlock()
+	return g
+}
+
+func newGroup(name string, getter Getter, o *groupOptions) *Group {
 	g := &Group{
 		name:   name,
 		getter: getter,
 		mainCache: cache{
-			cacheBytes: cacheBytes,
-			lru:        lru.New(cacheBytes, nil),
+			cacheBytes: o.cacheBytes,
+			lru:        o.policy(o.cacheBytes, nil),
+			policy:     o.policy,
 			mu:         sync.Mutex{},
 		},
+		hotCache: cache{
+			cacheBytes: int64(float64(o.cacheBytes) * o.hotCacheRatio),
+			policy:     o.policy,
+		},
+		peers:  o.peers,
 		loader: &singleflight.Group{},
+		opts:   *o,
+		logger: o.logger,
+		stats:  o.stats,
+	}
+	if o.keyProvider != nil {
+		g.crypter = newEnvelope(o.keyProvider)
+	}
+	if o.maxInFlight > 0 || o.originRate > 0 {
+		g.limiter = newOriginLimiter(o.maxInFlight, o.maxQueue, o.originRate, o.originBurst)
+	}
+	if o.hedgePercentile > 0 {
+		g.hedger = newHedger(o.hedgePercentile, o.hedgeBudget)
 	}
-	groups[name] = g
 	return g
 }
 
 func GetGroup(name string) *Group {
-	mu.RLock()
-	g := groups[name]
-	mu.RUnlock()
-	return g
+	return DefaultRegistry.GetGroup(name)
+}
+
+// Name 返回 Group 的名字
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Stats 返回 Group 的统计数据
+func (g *Group) Stats() *Stats {
+	return g.stats
 }
 
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 同 Get，回源排队时遵守 ctx 的超时和取消。
+// 同一个 key 的并发请求会被合并，合并后的加载使用第一个请求的 ctx
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
+	g.stats.Gets.Add(1)
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
+	if atomic.LoadInt32(&g.removed) == 1 {
+		return ByteView{}, ErrGroupRemoved
+	}
 
 	//从 mainCache 中查找缓存，如果存在则返回缓存值。
-	if v, ok := g.mainCache.get(key); ok {
-		log.Printf("gocache hit key = %s \n", key)
+	if v, ok := g.lookupCache(key); ok {
+		g.stats.CacheHits.Add(1)
+		g.logger.Printf("gocache hit key = %s \n", key)
 		return v, nil
 	}
-	return g.load(key)
+	g.stats.Loads.Add(1)
+	return g.load(ctx, key)
+}
+
+// lookupCache 依次从 mainCache、hotCache 取值，解密、解压后再返回给调用方
+func (g *Group) lookupCache(key string) (ByteView, bool) {
+	if v, ok := g.lookup(&g.mainCache, key); ok {
+		return v, ok
+	}
+	if g.opts.hotCacheRatio == 0 {
+		return ByteView{}, false
+	}
+	v, ok := g.lookup(&g.hotCache, key)
+	if ok {
+		g.stats.HotCacheHits.Add(1)
+	}
+	return v, ok
+}
+
+func (g *Group) lookup(c *cache, key string) (ByteView, bool) {
+	v, ok := c.get(key)
+	if !ok {
+		return v, ok
+	}
+	value, stale, err := g.decode(key, v)
+	if err != nil {
+		g.logger.Printf("[gocache] decode cached value of %s fail, the err: %v \n", key, err)
+		return ByteView{}, false
+	}
+	if stale { //key 已轮换，用新 key 重新加密；读取之后该 key 被并发更新时不覆盖新的值
+		if sealed, err := g.encode(key, value); err == nil {
+			c.replace(key, v, sealed)
+		}
+	}
+	return value, true
+}
+
+// encode 把明文转换为缓存中保存的形式：先压缩再加密
+func (g *Group) encode(key string, value ByteView) (ByteView, error) {
+	b := value.b
+	var err error
+	if g.opts.compressor != nil {
+		if b, err = compress(g.opts.compressor, g.opts.compressMin, b); err != nil {
+			return ByteView{}, err
+		}
+	}
+	if g.crypter != nil {
+		if b, err = g.crypter.seal(key, b); err != nil {
+			return ByteView{}, err
+		}
+	}
+	return ByteView{b: b, e: value.e, ct: value.ct}, nil
+}
+
+// decode 是 encode 的逆过程，stale 表示该值需要用当前 key 重新加密
+func (g *Group) decode(key string, v ByteView) (value ByteView, stale bool, err error) {
+	b := v.b
+	if g.crypter != nil {
+		if b, stale, err = g.crypter.open(key, b); err != nil {
+			return ByteView{}, false, err
+		}
+	}
+	if g.opts.compressor != nil {
+		if b, err = decompress(g.opts.compressor, b); err != nil {
+			return ByteView{}, false, err
+		}
+	}
+	return ByteView{b: b, e: v.e, ct: v.ct}, stale, nil
 }
 
 // 使用 PickPeer() 方法选择节点，若非本机节点，则调用 getFromPeer() 从远程获取。若是本机节点或失败，则回退到 getLocally()
-func (g *Group) load(key string) (value ByteView, err error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
 	viewi, err := g.loader.Do(key, func() (interface{}, error) { //将原来的 load 的逻辑，使用 g.loader.Do 包裹起来即可，这样确保了并发场景下针对相同的 key，load 过程只会调用一次。
-		if g.peers != nil {
-			log.Printf("consistent hash choose\n")
+		g.stats.LoadsDeduped.Add(1)
+		if rp, ok := g.peers.(ReplicaPicker); ok {
+			return g.loadReplicated(ctx, rp, key)
+		}
+		if g.peers != nil && !isLocalOnly(ctx) {
+			g.logger.Printf("consistent hash choose\n")
 			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err := g.getFromPeer(peer, key); err == nil {
-					log.Printf("[gocache] success get value from peer, %v \n", value)
-					return value, nil
+				value, hedged, err := g.hedged(ctx, g.fromPeer(peer, key), func(ctx context.Context) (ByteView, error) {
+					return g.getLocally(ctx, key)
+				})
+				if err == nil || hedged { //对冲请求已经在本地加载过
+					return value, err
 				}
-				log.Printf("[gocache] Failed to get from peer, %s %v \n", key, err)
 			}
 		}
-		return g.getLocally(key)
+		if value, ok := g.fromPrevious(ctx, key); ok {
+			return value, nil
+		}
+		return g.getLocally(ctx, key)
 	})
 	if err == nil {
 		return viewi.(ByteView), err //没有err 强转类型 返回数据
 	}
-	return ByteView{}, nil
+	return ByteView{}, err
+}
+
+type localOnlyKey struct{}
+
+// withLocalOnly 标记请求是其他节点转发来的，load 时不再转发给远程节点，
+// 避免节点之间哈希环不一致时同一个 key 被来回转发
+func withLocalOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, localOnlyKey{}, true)
+}
+
+func isLocalOnly(ctx context.Context) bool {
+	v, _ := ctx.Value(localOnlyKey{}).(bool)
+	return v
+}
+
+// loadReplicated 依次向主节点和副本节点读取，轮到本机或都失败时在本地加载，转发来的请求直接在本地加载。
+// 本机作为 owner 从数据源加载后，异步把值推送给其他 owner。开启对冲时慢的 owner 的对冲请求发给下一个 owner
+func (g *Group) loadReplicated(ctx context.Context, rp ReplicaPicker, key string) (ByteView, error) {
+	owners, self := rp.PickReplicas(key)
+	local := func(ctx context.Context) (ByteView, error) {
+		if value, ok := g.fromPrevious(ctx, key); ok {
+			return value, nil
+		}
+		value, err := g.getLocally(ctx, key)
+		if err == nil && self >= 0 {
+			go g.pushToReplicas(owners, self, key, value)
+		}
+		return value, err
+	}
+	for i := 0; i < len(owners) && i != self && !isLocalOnly(ctx); i++ {
+		backup, next := loadFunc(local), -1
+		if j := i + 1; j < len(owners) && j != self {
+			backup, next = g.fromPeer(owners[j], key), j
+		}
+		value, hedged, err := g.hedged(ctx, g.fromPeer(owners[i], key), backup)
+		if err == nil {
+			return value, nil
+		}
+		if hedged { //下一个 owner 或本地已经试过
+			if next < 0 {
+				return value, err
+			}
+			i = next
+		}
+	}
+	return local(ctx)
+}
+
+func (g *Group) pushToReplicas(owners []PeerGetter, self int, key string, value ByteView) {
+	req := value.setRequest(g.name, key)
+	for i, peer := range owners {
+		setter, ok := peer.(PeerSetter)
+		if i == self || !ok {
+			continue
+		}
+		if err := setter.Set(req, &pb.SetResponse{}); err != nil {
+			g.stats.ReplicaPushErrs.Add(1)
+			g.logger.Printf("[gocache] push %s to replica fail, the err: %v \n", key, err)
+			continue
+		}
+		g.stats.ReplicaPushes.Add(1)
+	}
+}
+
+// setLocally 把其他节点推送来的值写入 mainCache，超过 WithMaxValueBytes 的值被拒绝
+func (g *Group) setLocally(key string, value ByteView) error {
+	if err := g.checkSize(value); err != nil {
+		return err
+	}
+	g.populateCache(key, value)
+	return nil
+}
+
+// checkSize 在配置了 WithMaxValueBytes 时拒绝过大的值
+func (g *Group) checkSize(value ByteView) error {
+	if max := g.opts.maxValueBytes; max > 0 && int64(value.Len()) > max {
+		return fmt.Errorf("%w: %d bytes, max %d", ErrValueTooLarge, value.Len(), max)
+	}
+	return nil
+}
+
+// purgeLocally 清空本机的 mainCache 和 hotCache
+func (g *Group) purgeLocally() {
+	g.mainCache.clear()
+	g.hotCache.clear()
+}
+
+// removeLocally 从本机的 mainCache 和 hotCache 中删除 key
+func (g *Group) removeLocally(key string) {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+}
+
+// set 把值写入 key 的 owner：本机是 owner 时写入本地缓存，远程 owner 通过 PeerSetter 同步推送
+func (g *Group) set(key string, value ByteView) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if atomic.LoadInt32(&g.removed) == 1 {
+		return ErrGroupRemoved
+	}
+	if err := g.checkSize(value); err != nil {
+		return err
+	}
+	owners, self := g.owners(key)
+	if self >= 0 {
+		g.setLocally(key, value)
+	} else {
+		g.hotCache.remove(key) //本机不是 owner，去掉 hotCache 中的旧值
+	}
+	req := value.setRequest(g.name, key)
+	for i, peer := range owners {
+		if i == self {
+			continue
+		}
+		setter, ok := peer.(PeerSetter)
+		if !ok {
+			return fmt.Errorf("gocache: peer of %s does not support set", key)
+		}
+		if err := setter.Set(req, &pb.SetResponse{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove 从本机和 key 的所有远程 owner 的缓存中删除 key
+func (g *Group) remove(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.removeLocally(key)
+	owners, self := g.owners(key)
+	req := &pb.DeleteRequest{Group: g.name, Key: key}
+	for i, peer := range owners {
+		if i == self {
+			continue
+		}
+		deleter, ok := peer.(PeerDeleter)
+		if !ok {
+			return fmt.Errorf("gocache: peer of %s does not support delete", key)
+		}
+		if err := deleter.Delete(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// owners 返回 key 的 owner 列表，self 为本机在列表中的位置。没有注册节点或远程 owner 都不可用时本机是唯一的 owner
+func (g *Group) owners(key string) (owners []PeerGetter, self int) {
+	if rp, ok := g.peers.(ReplicaPicker); ok {
+		if owners, self = rp.PickReplicas(key); len(owners) > 0 {
+			return owners, self
+		}
+	} else if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			return []PeerGetter{peer}, -1
+		}
+	}
+	return []PeerGetter{nil}, 0
 }
 
 //获取源数据，并且将源数据添加到缓存 mainCache 中（通过 populateCache 方法）
-func (g *Group) getLocally(key string) (ByteView, error) {
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	if g.limiter != nil {
+		release, err := g.limiter.acquire(ctx)
+		if err != nil {
+			if err == ErrOriginOverloaded {
+				g.stats.OriginRejects.Add(1)
+			}
+			g.logger.Printf("[gocache] Get Local data of %s rejected, the err: %v \n", key, err)
+			return ByteView{}, err
+		}
+		defer release()
+	}
 	bytes, err := g.getter.Get(key)
 	if err != nil {
-		log.Printf("[gocache] Get Local data fail, the err: %v \n", err)
+		g.stats.LocalLoadErrs.Add(1)
+		g.logger.Printf("[gocache] Get Local data fail, the err: %v \n", err)
 		return ByteView{}, err
 
 	}
+	if max := g.opts.maxValueBytes; max > 0 && int64(len(bytes)) > max {
+		g.stats.LocalLoadErrs.Add(1)
+		return ByteView{}, fmt.Errorf("%w: %s is %d bytes, max %d", ErrValueTooLarge, key, len(bytes), max)
+	}
+	g.stats.LocalLoads.Add(1)
 	value := ByteView{b: cloneBytes(bytes)}
-	log.Printf("[gocache] Get Local data ok, put value to Cache, %v \n", value.String())
+	if g.opts.ttl > 0 {
+		value.e = time.Now().Add(g.opts.ttl)
+	}
+	g.logger.Printf("[gocache] Get Local data ok, put %s (%d bytes) to Cache \n", key, value.Len()) //不记录值本身，避免明文写入日志
 	g.populateCache(key, value)
 	return value, nil
 }
 
 func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+	g.store(&g.mainCache, key, value)
 }
 
-func (g *Group) RegisterPeers(peers PeerPicker) {
+func (g *Group) store(c *cache, key string, value ByteView) {
+	if atomic.LoadInt32(&g.removed) == 1 {
+		return
+	}
+	if g.opts.admission != nil && !g.opts.admission(key, value) {
+		return
+	}
+	stored, err := g.encode(key, value)
+	if err != nil {
+		g.logger.Printf("[gocache] encode value of %s fail, the err: %v \n", key, err)
+		return
+	}
+	c.add(key, stored)
+}
+
+// RegisterKeyProvider 开启缓存值的 AES-GCM 加密，需在 Group 缓存任何数据之前调用，重复调用返回 ErrKeyProviderRegistered
+func (g *Group) RegisterKeyProvider(kp KeyProvider) error {
+	if g.crypter != nil {
+		return ErrKeyProviderRegistered
+	}
+	g.crypter = newEnvelope(kp)
+	return nil
+}
+
+// RegisterPeers 设置选择远程节点的 PeerPicker，重复调用返回 ErrPeersRegistered
+func (g *Group) RegisterPeers(peers PeerPicker) error {
 	if g.peers != nil {
-		panic("RegisterPeerPicker called more than once")
+		return ErrPeersRegistered
 	}
 	g.peers = peers
+	return nil
 }
 
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+// fromPeer 返回从 peer 读取 key 的 loadFunc，记录统计数据，被对冲请求取消的读取不计为失败
+func (g *Group) fromPeer(peer PeerGetter, key string) loadFunc {
+	return func(ctx context.Context) (ByteView, error) {
+		value, err := g.getFromPeer(ctx, peer, key)
+		if err == nil {
+			g.stats.PeerLoads.Add(1)
+			g.logger.Printf("[gocache] success get %s (%d bytes) from peer \n", key, value.Len())
+			return value, nil
+		}
+		if ctx.Err() == nil {
+			g.stats.PeerErrors.Add(1)
+			g.logger.Printf("[gocache] Failed to get from peer, %s %v \n", key, err)
+		}
+		return ByteView{}, err
+	}
+}
+
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	//bytes, err := peer.Get(g.name, key)
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	start := time.Now()
+	var err error
+	if cg, ok := peer.(PeerContextGetter); ok {
+		err = cg.GetContext(ctx, req, res)
+	} else {
+		err = peer.Get(req, res)
+	}
 
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, nil
+	if g.hedger != nil {
+		g.hedger.observe(time.Since(start))
+	}
+	value := viewOf(res.Value, res.Expire, res.ContentType)
+	if g.opts.hotCacheRatio > 0 && rand.Intn(10) == 0 { //只缓存一部分远程取回的值，避免 hotCache 被冷数据占满
+		if g.opts.ttl > 0 && value.e.IsZero() {
+			value.e = time.Now().Add(g.opts.ttl)
+		}
+		g.store(&g.hotCache, key, value)
+	}
+	return value, nil
 }
@@ -29,7 +29,7 @@ func TestGet(t *testing.T) {
 
 	loadCounts := make(map[string]int, len(db)) //每个key 的load次数
 
-	gee := NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+	gee, _ := NewRegistry().NewGroup("scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
 		log.Printf("[DB] search key = %s \n", key)
 		if v, ok := db[key]; ok {
 			if _, ok := loadCounts[key]; !ok {
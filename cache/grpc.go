@@ -0,0 +1,335 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-tools/consistenthash"
+	pb "go-tools/gocachepb"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultGRPCTimeout = 5 * time.Second
+	//gRPC metadata 的 key 必须是小写，含义同 forwardedByHeader
+	forwardedByMetadata = "x-gocache-forwarded-by"
+)
+
+var _ PeerPicker = (*GRPCPool)(nil)
+var _ ReplicaPicker = (*GRPCPool)(nil)
+var _ PeerGetter = (*grpcGetter)(nil)
+var _ PeerSetter = (*grpcGetter)(nil)
+var _ PeerContextGetter = (*grpcGetter)(nil)
+var _ PeerDeleter = (*grpcGetter)(nil)
+var _ pb.GroupCacheServer = (*grpcServer)(nil)
+
+// GRPCPool 是 HTTPPool 的替代，节点之间通过 gocachepb.GroupCache 服务通信。
+// 节点地址为 host:port，每个远程节点保持一个 grpc.ClientConn
+type GRPCPool struct {
+	self    string
+	opts    GRPCPoolOptions
+	mu      sync.Mutex
+	peers   *consistenthash.Map
+	getters map[string]*grpcGetter
+}
+
+// GRPCPoolOptions 是 GRPCPool 的可选配置，零值字段使用默认值
+type GRPCPoolOptions struct {
+	// Replicas 一致性哈希中每个节点的虚拟节点数，默认 50
+	Replicas int
+	// HashFn 一致性哈希函数，默认 crc32.ChecksumIEEE
+	HashFn consistenthash.Hash
+	// Registry 服务端查找 Group 的 Registry，默认 DefaultRegistry
+	Registry *Registry
+	// ReplicationFactor 每个 key 的 owner 数量（主节点加副本），默认 1 即不复制
+	ReplicationFactor int
+	// Timeout 单次 RPC 的超时时间，默认 5s
+	Timeout time.Duration
+	// DialOptions 连接其他节点时使用的选项，默认不加密
+	DialOptions []grpc.DialOption
+}
+
+// NewGRPCPool 创建 GRPCPool，o 为 nil 时使用默认配置
+func NewGRPCPool(self string, o *GRPCPoolOptions) *GRPCPool {
+	p := &GRPCPool{self: self, getters: make(map[string]*grpcGetter)}
+	if o != nil {
+		p.opts = *o
+	}
+	if p.opts.Replicas == 0 {
+		p.opts.Replicas = defaultReplicas
+	}
+	if p.opts.Registry == nil {
+		p.opts.Registry = DefaultRegistry
+	}
+	if p.opts.ReplicationFactor < 1 {
+		p.opts.ReplicationFactor = 1
+	}
+	if p.opts.Timeout <= 0 {
+		p.opts.Timeout = defaultGRPCTimeout
+	}
+	if len(p.opts.DialOptions) == 0 {
+		p.opts.DialOptions = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	return p
+}
+
+func (p *GRPCPool) Log(format string, v ...interface{}) {
+	log.Printf("[gRPC %s] %s", p.self, fmt.Sprintf(format, v...))
+}
+
+// Register 在 s 上注册 GroupCache 服务
+func (p *GRPCPool) Register(s *grpc.Server) {
+	pb.RegisterGroupCacheServer(s, &grpcServer{registry: p.opts.Registry})
+}
+
+// Set 更新节点列表，已有节点的连接保持不变，被移除节点的连接会被关闭
+func (p *GRPCPool) Set(peers ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sorted := append([]string(nil), peers...)
+	sort.Strings(sorted) //按固定顺序添加，保证各节点的哈希环一致
+
+	getters := make(map[string]*grpcGetter, len(sorted))
+	for _, peer := range sorted {
+		if g, ok := p.getters[peer]; ok {
+			getters[peer] = g
+			continue
+		}
+		if peer == p.self {
+			continue
+		}
+		//passthrough 直接把地址交给 dialer，与 net.Dial 的行为一致
+		conn, err := grpc.NewClient("passthrough:///"+peer, p.opts.DialOptions...)
+		if err != nil {
+			for peer, g := range getters {
+				if _, ok := p.getters[peer]; !ok {
+					g.conn.Close()
+				}
+			}
+			return err
+		}
+		getters[peer] = &grpcGetter{
+			peer:    peer,
+			self:    p.self,
+			conn:    conn,
+			client:  pb.NewGroupCacheClient(conn),
+			timeout: p.opts.Timeout,
+		}
+	}
+	for peer, g := range p.getters {
+		if _, ok := getters[peer]; !ok {
+			g.conn.Close()
+		}
+	}
+	p.getters = getters
+	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	p.peers.Add(sorted...)
+	return nil
+}
+
+// Close 关闭到所有节点的连接
+func (p *GRPCPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, g := range p.getters {
+		g.conn.Close()
+	}
+	p.getters = make(map[string]*grpcGetter)
+	p.peers = nil
+	return nil
+}
+
+// PickPeer 选择 key 的 owner，owner 是本机节点时返回 false
+func (p *GRPCPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+	if peer := p.peers.Get(key); peer != "" && peer != p.self {
+		p.Log("Ready to Pick peer %s", peer)
+		return p.getters[peer], true
+	}
+	return nil, false
+}
+
+// PickReplicas 返回 key 在哈希环上的前 ReplicationFactor 个节点
+func (p *GRPCPool) PickReplicas(key string) ([]PeerGetter, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, -1
+	}
+	self := -1
+	owners := make([]PeerGetter, 0, p.opts.ReplicationFactor)
+	for _, peer := range p.peers.GetN(key, p.opts.ReplicationFactor) {
+		if peer == p.self {
+			self = len(owners)
+			owners = append(owners, nil)
+		} else {
+			owners = append(owners, p.getters[peer])
+		}
+	}
+	return owners, self
+}
+
+// grpcGetter 是访问某个远程节点的 gRPC 客户端
+type grpcGetter struct {
+	peer    string
+	self    string
+	conn    *grpc.ClientConn
+	client  pb.GroupCacheClient
+	timeout time.Duration
+}
+
+func (g *grpcGetter) Get(in *pb.Request, out *pb.Response) error {
+	return g.GetContext(context.Background(), in, out)
+}
+
+// GetContext 同 Get，ctx 被取消时中断请求
+func (g *grpcGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	ctx, cancel := context.WithTimeout(g.forwarded(ctx), g.timeout)
+	defer cancel()
+	res, err := g.client.Get(ctx, in)
+	if status.Code(err) == codes.NotFound { //与 HTTP 的 404 一致，调用方可以区分未命中和失败
+		return notFoundError{err}
+	}
+	if err != nil {
+		return err
+	}
+	out.Value, out.Expire, out.ContentType = res.GetValue(), res.GetExpire(), res.GetContentType()
+	return nil
+}
+
+func (g *grpcGetter) Set(in *pb.SetRequest, out *pb.SetResponse) error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	_, err := g.client.Set(ctx, in)
+	return err
+}
+
+// Delete 删除远程节点缓存中的 key
+func (g *grpcGetter) Delete(ctx context.Context, in *pb.DeleteRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	_, err := g.client.Delete(ctx, in)
+	return err
+}
+
+// MultiGet 一次从远程节点读取多个 key
+func (g *grpcGetter) MultiGet(ctx context.Context, in *pb.MultiGetRequest) (*pb.MultiGetResponse, error) {
+	ctx, cancel := context.WithTimeout(g.forwarded(ctx), g.timeout)
+	defer cancel()
+	return g.client.MultiGet(ctx, in)
+}
+
+// forwarded 在请求的 metadata 中带上本机地址，对方只在本机处理，不再转发
+func (g *grpcGetter) forwarded(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, forwardedByMetadata, g.self)
+}
+
+// notFoundError 是对方返回的 NotFound，保留 gRPC 状态码，同时 errors.Is(err, ErrNotFound) 为 true
+type notFoundError struct{ error }
+
+func (e notFoundError) GRPCStatus() *status.Status { return status.Convert(e.error) }
+
+func (e notFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// grpcServer 实现 GroupCache 服务
+type grpcServer struct {
+	pb.UnimplementedGroupCacheServer
+	registry *Registry
+}
+
+func (s *grpcServer) group(name string) (*Group, error) {
+	group := s.registry.GetGroup(name)
+	if group == nil {
+		return nil, status.Errorf(codes.NotFound, "no such group: %s", name)
+	}
+	return group, nil
+}
+
+// localOnly 让其他节点转发来的请求总是在本机处理
+func localOnly(ctx context.Context) context.Context {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(forwardedByMetadata)) > 0 {
+		return withLocalOnly(ctx)
+	}
+	return ctx
+}
+
+func (s *grpcServer) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	group, err := s.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	view, err := group.GetContext(localOnly(ctx), in.GetKey())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return view.response(), nil
+}
+
+func (s *grpcServer) Set(ctx context.Context, in *pb.SetRequest) (*pb.SetResponse, error) {
+	group, err := s.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	if err := group.setLocally(in.GetKey(), viewOf(in.GetValue(), in.GetExpire(), in.GetContentType())); err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.SetResponse{}, nil
+}
+
+func (s *grpcServer) Delete(ctx context.Context, in *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	group, err := s.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.removeLocally(in.GetKey())
+	return &pb.DeleteResponse{}, nil
+}
+
+func (s *grpcServer) MultiGet(ctx context.Context, in *pb.MultiGetRequest) (*pb.MultiGetResponse, error) {
+	group, err := s.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	ctx = localOnly(ctx)
+	out := &pb.MultiGetResponse{Values: make(map[string][]byte), Errors: make(map[string]string)}
+	for _, key := range in.GetKeys() {
+		view, err := group.GetContext(ctx, key)
+		if err != nil {
+			out.Errors[key] = err.Error()
+			continue
+		}
+		out.Values[key] = view.ByteSlice()
+	}
+	return out, nil
+}
+
+// grpcError 把 Group 的错误转换为 gRPC 状态码
+func grpcError(err error) error {
+	code := codes.Unknown
+	switch {
+	case errors.Is(err, ErrValueTooLarge):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrGroupRemoved):
+		code = codes.NotFound
+	case errors.Is(err, ErrOriginOverloaded):
+		code = codes.ResourceExhausted
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, err.Error())
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	pb "go-tools/gocachepb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type grpcTestNode struct {
+	pool  *GRPCPool
+	group *Group
+	loads *int32
+}
+
+// newGRPCTestCluster 启动 n 个通过 bufconn 通信的节点，节点地址为 node0、node1 ...
+func newGRPCTestCluster(t *testing.T, n int) []*grpcTestNode {
+	listeners := make(map[string]*bufconn.Listener)
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("node%d", i)
+		listeners[addrs[i]] = bufconn.Listen(1 << 20)
+	}
+	dialer := grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		return listeners[addr].DialContext(ctx)
+	})
+
+	nodes := make([]*grpcTestNode, n)
+	for i, addr := range addrs {
+		node := &grpcTestNode{loads: new(int32)}
+		r := NewRegistry()
+		node.pool = NewGRPCPool(addr, &GRPCPoolOptions{
+			Registry:    r,
+			DialOptions: []grpc.DialOption{dialer, grpc.WithTransportCredentials(insecure.NewCredentials())},
+		})
+		if err := node.pool.Set(addrs...); err != nil {
+			t.Fatal(err)
+		}
+		node.group, _ = r.NewGroupOpts("group", GetterFunc(func(key string) ([]byte, error) {
+			atomic.AddInt32(node.loads, 1)
+			return []byte("v-" + key), nil
+		}), WithPeerPicker(node.pool))
+
+		srv := grpc.NewServer()
+		node.pool.Register(srv)
+		go srv.Serve(listeners[addr])
+		t.Cleanup(func() {
+			srv.Stop()
+			node.pool.Close()
+		})
+		nodes[i] = node
+	}
+	return nodes
+}
+
+func TestGRPCPool(t *testing.T) {
+	nodes := newGRPCTestCluster(t, 3)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprint(i)
+		for _, node := range nodes {
+			if v, err := node.group.Get(key); err != nil || v.String() != "v-"+key {
+				t.Fatalf("Get(%s) = %q, %v", key, v.String(), err)
+			}
+		}
+	}
+	var loads int32
+	for _, node := range nodes {
+		loads += *node.loads
+	}
+	if loads != 30 {
+		t.Fatalf("each key should be loaded once by its owner, loads = %d", loads)
+	}
+	if nodes[0].group.Stats().PeerLoads.Get() == 0 {
+		t.Fatal("expect some keys to be loaded from peers")
+	}
+}
+
+func TestGRPCServer(t *testing.T) {
+	nodes := newGRPCTestCluster(t, 2)
+	peer := nodes[0].pool.getters["node1"]
+	ctx := context.Background()
+	target := nodes[1].group
+
+	if err := peer.Set(&pb.SetRequest{Group: "group", Key: "k", Value: []byte("pushed")}, &pb.SetResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := target.lookupCache("k"); !ok || v.String() != "pushed" {
+		t.Fatalf("Set should populate the peer cache, got %q %v", v.String(), ok)
+	}
+
+	res, err := peer.MultiGet(ctx, &pb.MultiGetRequest{Group: "group", Keys: []string{"k", "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.GetValues()["k"]) != "pushed" || string(res.GetValues()["a"]) != "v-a" || len(res.GetErrors()) != 0 {
+		t.Fatalf("MultiGet = %v, %v", res.GetValues(), res.GetErrors())
+	}
+
+	if err := peer.Delete(ctx, &pb.DeleteRequest{Group: "group", Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := target.lookupCache("k"); ok {
+		t.Fatal("Delete should remove the key from the peer cache")
+	}
+
+	err = peer.Get(&pb.Request{Group: "unknown", Key: "k"}, &pb.Response{})
+	if status.Code(err) != codes.NotFound || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get from an unknown group = %v, expect NotFound", err)
+	}
+}
+
+func TestGRPCError(t *testing.T) {
+	for err, code := range map[error]codes.Code{
+		fmt.Errorf("tom: %w", ErrNotFound):                 codes.NotFound,
+		ErrGroupRemoved:                                    codes.NotFound,
+		fmt.Errorf("load: %w", ErrOriginOverloaded):        codes.ResourceExhausted,
+		fmt.Errorf("%w: 10 bytes", ErrValueTooLarge):       codes.InvalidArgument,
+		fmt.Errorf("peer: %w", context.Canceled):           codes.Canceled,
+		fmt.Errorf("origin: %w", context.DeadlineExceeded): codes.DeadlineExceeded,
+		errors.New("origin failed"):                        codes.Unknown,
+	} {
+		if got := status.Code(grpcError(err)); got != code {
+			t.Errorf("grpcError(%v) = %v, expect %v", err, got, code)
+		}
+	}
+}
+
+func TestGRPCForwardedLocalOnly(t *testing.T) {
+	nodes := newGRPCTestCluster(t, 2)
+	// 两个节点的哈希环不一致，都认为对方是 owner，转发来的请求不能再转发回去
+	nodes[0].pool.Set("node1")
+	nodes[1].pool.Set("node0")
+	if v, err := nodes[0].group.Get("key"); err != nil || v.String() != "v-key" {
+		t.Fatalf("Get = %q, %v", v.String(), err)
+	}
+	if *nodes[0].loads != 0 || *nodes[1].loads != 1 {
+		t.Fatalf("the forwarded request should be served by node1, loads = %d %d", *nodes[0].loads, *nodes[1].loads)
+	}
+}
@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	healthPath              = "_health"
+	defaultFailureThreshold = 3
+	defaultMinBackoff       = time.Second
+	defaultMaxBackoff       = 30 * time.Second
+)
+
+// PeerStatus 是某个节点的健康状态
+type PeerStatus struct {
+	Healthy   bool
+	Failures  int       // 连续失败次数
+	DownUntil time.Time // 在此之前 PickPeer 会跳过该节点
+}
+
+// peerHealth 记录节点的健康状态：被动统计请求失败次数，达到阈值后按指数退避暂时摘除节点；
+// 退避结束后节点重新参与选择，再次失败则退避时间翻倍。主动探测成功会立即恢复节点
+type peerHealth struct {
+	mu         sync.Mutex
+	threshold  int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	peers      map[string]*peerState
+	now        func() time.Time
+}
+
+type peerState struct {
+	failures  int
+	backoff   time.Duration
+	downUntil time.Time
+}
+
+func newPeerHealth(o *HTTPPoolOptions) *peerHealth {
+	h := &peerHealth{
+		threshold:  o.FailureThreshold,
+		minBackoff: o.MinBackoff,
+		maxBackoff: o.MaxBackoff,
+		peers:      make(map[string]*peerState),
+		now:        time.Now,
+	}
+	if h.threshold <= 0 {
+		h.threshold = defaultFailureThreshold
+	}
+	if h.minBackoff <= 0 {
+		h.minBackoff = defaultMinBackoff
+	}
+	if h.maxBackoff < h.minBackoff {
+		h.maxBackoff = defaultMaxBackoff
+	}
+	return h
+}
+
+func (h *peerHealth) state(peer string) *peerState {
+	s, ok := h.peers[peer]
+	if !ok {
+		s = &peerState{}
+		h.peers[peer] = s
+	}
+	return s
+}
+
+func (h *peerHealth) healthy(peer string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.peers[peer]
+	return !ok || !h.now().Before(s.downUntil)
+}
+
+// success 记录一次成功，节点恢复健康并重置退避时间
+func (h *peerHealth) success(peer string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.peers, peer)
+}
+
+// failure 记录一次失败，返回节点是否因此被摘除；已被摘除的节点不会重复延长退避时间
+func (h *peerHealth) failure(peer string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.state(peer)
+	s.failures++
+	if s.failures < h.threshold || h.now().Before(s.downUntil) {
+		return false
+	}
+	if s.backoff == 0 {
+		s.backoff = h.minBackoff
+	} else if s.backoff *= 2; s.backoff > h.maxBackoff {
+		s.backoff = h.maxBackoff
+	}
+	s.downUntil = h.now().Add(s.backoff)
+	return true
+}
+
+// retain 删除不在 peers 中的节点的状态
+func (h *peerHealth) retain(peers []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keep := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		keep[peer] = true
+	}
+	for peer := range h.peers {
+		if !keep[peer] {
+			delete(h.peers, peer)
+		}
+	}
+}
+
+func (h *peerHealth) status(peer string) PeerStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.peers[peer]
+	if !ok {
+		return PeerStatus{Healthy: true}
+	}
+	return PeerStatus{
+		Healthy:   !h.now().Before(s.downUntil),
+		Failures:  s.failures,
+		DownUntil: s.downUntil,
+	}
+}
+
+// PeerStatuses 返回所有远程节点的健康状态
+func (p *HTTPPool) PeerStatuses() map[string]PeerStatus {
+	p.mu.Lock()
+	peers := make([]string, 0, len(p.httpGetters))
+	for peer := range p.httpGetters {
+		if peer != p.self {
+			peers = append(peers, peer)
+		}
+	}
+	p.mu.Unlock()
+
+	statuses := make(map[string]PeerStatus, len(peers))
+	for _, peer := range peers {
+		statuses[peer] = p.health.status(peer)
+	}
+	return statuses
+}
+
+// report 记录一次对 peer 请求的结果，用于被动健康检查
+func (p *HTTPPool) report(peer string, err error) {
+	if err == nil {
+		p.health.success(peer)
+		return
+	}
+	if p.health.failure(peer) {
+		p.Log("peer %s marked unhealthy: %v", peer, err)
+	}
+}
+
+// probeLoop 每隔 HealthCheckInterval 主动探测所有远程节点，直到 Close
+func (p *HTTPPool) probeLoop(interval time.Duration) {
+	client := &http.Client{Transport: p.client.Transport, Timeout: interval}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		for peer := range p.PeerStatuses() {
+			go p.probe(client, peer)
+		}
+		for _, peer := range p.leftPeers() {
+			go p.probeLeft(client, peer)
+		}
+	}
+}
+
+func (p *HTTPPool) probe(client *http.Client, peer string) {
+	err := p.check(client, peer)
+	wasHealthy := p.health.healthy(peer)
+	p.report(peer, err)
+	if err == nil && !wasHealthy {
+		p.Log("peer %s is healthy again", peer)
+	}
+}
+
+// probeLeft 探测主动退出的节点，成功说明节点已经重新启动，把它加回哈希环。
+// 正在退出的节点健康检查返回 503，不会被提前加回
+func (p *HTTPPool) probeLeft(client *http.Client, peer string) {
+	if p.check(client, peer) != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.left[peer]; ok {
+		p.rejoin(peer)
+	}
+}
+
+// check 请求 peer 的健康检查接口
+func (p *HTTPPool) check(client *http.Client, peer string) error {
+	res, err := client.Get(peer + p.basePath + healthPath)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned: %v", res.Status)
+	}
+	return nil
+}
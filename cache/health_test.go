@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "go-tools/gocachepb"
+)
+
+func TestPeerFailover(t *testing.T) {
+	r := NewRegistry()
+	r.NewGroup("health", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	var down int32
+	serve := NewHTTPPoolOpts("", &HTTPPoolOptions{Registry: r})
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		serve.ServeHTTP(w, req)
+	}))
+	defer flaky.Close()
+	stable := httptest.NewServer(serve)
+	defer stable.Close()
+
+	pool := NewHTTPPoolOpts("self", &HTTPPoolOptions{
+		FailureThreshold:    1,
+		MinBackoff:          time.Minute,
+		HealthCheckInterval: 10 * time.Millisecond,
+	})
+	defer pool.Close()
+	pool.Set(flaky.URL, stable.URL)
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if owners := pool.peers.GetN(fmt.Sprint(i), 2); owners[0] == flaky.URL {
+			key = fmt.Sprint(i)
+		}
+	}
+	get := func() (*httpGetter, error) {
+		peer, ok := pool.PickPeer(key)
+		if !ok {
+			t.Fatal("expect a remote peer")
+		}
+		return peer.(*httpGetter), peer.Get(&pb.Request{Group: "health", Key: key}, &pb.Response{})
+	}
+
+	atomic.StoreInt32(&down, 1)
+	if peer, err := get(); peer.peer != flaky.URL || err == nil {
+		t.Fatalf("first request should go to the owner and fail, got %s %v", peer.peer, err)
+	}
+	if peer, err := get(); peer.peer != stable.URL || err != nil {
+		t.Fatalf("unhealthy owner should be skipped, got %s %v", peer.peer, err)
+	}
+	if pool.PeerStatuses()[flaky.URL].Healthy {
+		t.Fatal("owner should be reported unhealthy")
+	}
+
+	//探测成功后在退避结束前恢复
+	atomic.StoreInt32(&down, 0)
+	deadline := time.Now().Add(time.Second)
+	for !pool.PeerStatuses()[flaky.URL].Healthy {
+		if time.Now().After(deadline) {
+			t.Fatal("owner should be re-admitted after a successful probe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if peer, err := get(); peer.peer != flaky.URL || err != nil {
+		t.Fatalf("requests should go back to the owner, got %s %v", peer.peer, err)
+	}
+}
+
+func TestPeerBackoff(t *testing.T) {
+	h := newPeerHealth(&HTTPPoolOptions{FailureThreshold: 2, MinBackoff: 10 * time.Millisecond, MaxBackoff: 15 * time.Millisecond})
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	if h.failure("a") || !h.healthy("a") {
+		t.Fatal("peer should stay healthy below threshold")
+	}
+	if !h.failure("a") || h.healthy("a") {
+		t.Fatal("peer should be marked down at threshold")
+	}
+	now = now.Add(10 * time.Millisecond)
+	if !h.healthy("a") {
+		t.Fatal("peer should be retried after backoff")
+	}
+	h.failure("a")
+	if s := h.peers["a"]; s.backoff != 15*time.Millisecond {
+		t.Fatalf("backoff = %v, expect doubled and capped at 15ms", s.backoff)
+	}
+	h.success("a")
+	if st := h.status("a"); !st.Healthy || st.Failures != 0 {
+		t.Fatalf("success should reset state, got %+v", st)
+	}
+}
+
+func TestOriginErrorKeepsPeerHealthy(t *testing.T) {
+	code := int32(http.StatusInternalServerError)
+	pool, peer := newClientTestPool(t, HTTPPoolOptions{FailureThreshold: 1, MinBackoff: time.Minute}, func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "origin failed", int(atomic.LoadInt32(&code)))
+	})
+	url := peer.(*httpGetter).peer
+	for _, c := range []int32{http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		atomic.StoreInt32(&code, c)
+		if err := peer.Get(&pb.Request{Group: "health", Key: "key"}, &pb.Response{}); err == nil {
+			t.Fatalf("%d: expect error", c)
+		}
+		if !pool.PeerStatuses()[url].Healthy {
+			t.Fatalf("%d from the origin should not mark the peer unhealthy", c)
+		}
+	}
+	atomic.StoreInt32(&code, http.StatusBadGateway)
+	peer.Get(&pb.Request{Group: "health", Key: "key"}, &pb.Response{})
+	if pool.PeerStatuses()[url].Healthy {
+		t.Fatal("502 should mark the peer unhealthy")
+	}
+}
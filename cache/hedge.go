@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	hedgeWindow     = 512 //保留最近多少次远程读取的延迟
+	hedgeMinSamples = 32  //样本不足时不发对冲请求，每积累这么多样本重新计算一次阈值
+	hedgeMaxTokens  = 10  //预算最多积攒的对冲请求数，避免长时间空闲后突然发出大量对冲请求
+)
+
+// loadFunc 加载 key 的一种方式，被对冲请求取消时 ctx 会被取消
+type loadFunc func(ctx context.Context) (ByteView, error)
+
+// hedger 记录远程读取的延迟分布，远程节点超过 percentile 分位的延迟仍未返回时允许发出对冲请求，
+// 对冲请求的数量不超过远程读取次数的 budget 比例
+type hedger struct {
+	percentile float64
+	budget     float64
+
+	mu        sync.Mutex
+	samples   [hedgeWindow]time.Duration
+	n         int           //累计的样本数
+	threshold time.Duration //样本不足时为 0
+	tokens    float64
+}
+
+func newHedger(percentile, budget float64) *hedger {
+	return &hedger{percentile: percentile, budget: budget}
+}
+
+// observe 记录一次成功的远程读取的延迟
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples[h.n%hedgeWindow] = d
+	h.n++
+	if h.n%hedgeMinSamples != 0 {
+		return
+	}
+	n := h.n
+	if n > hedgeWindow {
+		n = hedgeWindow
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, h.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	h.threshold = sorted[int(h.percentile*float64(n-1))]
+}
+
+// delay 返回发出对冲请求前的等待时间，同时为这次远程读取积攒预算。样本不足时 ok 为 false
+func (h *hedger) delay() (d time.Duration, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens += h.budget; h.tokens > hedgeMaxTokens {
+		h.tokens = hedgeMaxTokens
+	}
+	return h.threshold, h.threshold > 0
+}
+
+// spend 消耗一次对冲请求的预算，预算不足时返回 false
+func (h *hedger) spend() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+type hedgeResult struct {
+	value  ByteView
+	err    error
+	backup bool
+}
+
+// hedged 调用 primary，超过延迟阈值仍未返回且预算允许时再调用 backup，返回先成功的结果并取消另一个。
+// hedged 为 true 表示 backup 已被调用，两者都失败时返回 backup 的错误。
+// 回源的 Getter 不支持取消，backup 是本地加载时即使 primary 先返回，加载到的值仍会写入缓存
+func (g *Group) hedged(ctx context.Context, primary, backup loadFunc) (value ByteView, hedged bool, err error) {
+	h := g.hedger
+	if h == nil {
+		value, err = primary(ctx)
+		return value, false, err
+	}
+	delay, ok := h.delay()
+	if !ok {
+		value, err = primary(ctx)
+		return value, false, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() //返回时取消还没有完成的一方
+	results := make(chan hedgeResult, 2)
+	run := func(load loadFunc, backup bool) {
+		go func() {
+			value, err := load(ctx)
+			results <- hedgeResult{value: value, err: err, backup: backup}
+		}()
+	}
+	run(primary, false)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.value, false, r.err
+	case <-timer.C:
+	}
+	if !h.spend() {
+		g.stats.HedgeSkips.Add(1)
+		r := <-results
+		return r.value, false, r.err
+	}
+
+	g.stats.Hedges.Add(1)
+	run(backup, true)
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err == nil {
+			if r.backup {
+				g.stats.HedgeWins.Add(1)
+			}
+			return r.value, true, nil
+		}
+		if r.backup || err == nil {
+			err = r.err
+		}
+	}
+	return ByteView{}, true, err
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"context"
+	pb "go-tools/gocachepb"
+	"testing"
+	"time"
+)
+
+// slowPeer 等待 delay 后返回，期间 ctx 被取消时记录到 canceled
+type slowPeer struct {
+	delay    time.Duration
+	value    string
+	canceled chan struct{}
+}
+
+func (p *slowPeer) Get(in *pb.Request, out *pb.Response) error {
+	return p.GetContext(context.Background(), in, out)
+}
+
+func (p *slowPeer) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	select {
+	case <-time.After(p.delay):
+		out.Value = []byte(p.value)
+		return nil
+	case <-ctx.Done():
+		close(p.canceled)
+		return ctx.Err()
+	}
+}
+
+type fixedReplicas []PeerGetter
+
+func (r fixedReplicas) PickPeer(key string) (PeerGetter, bool) { return r[0], true }
+
+func (r fixedReplicas) PickReplicas(key string) ([]PeerGetter, int) { return r, -1 }
+
+type fixedPeer struct{ PeerGetter }
+
+func (p fixedPeer) PickPeer(key string) (PeerGetter, bool) { return p.PeerGetter, true }
+
+func newHedgedGroup(t *testing.T, peers PeerPicker, budget float64) *Group {
+	g, err := NewRegistry().NewGroupOpts("group", GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}), WithPeerPicker(peers), WithHedging(0.9, budget))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < hedgeMinSamples; i++ {
+		g.hedger.observe(time.Millisecond)
+	}
+	return g
+}
+
+func TestHedger(t *testing.T) {
+	h := newHedger(0.9, 0.25)
+	for i := 1; i < hedgeMinSamples; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	if _, ok := h.delay(); ok {
+		t.Fatal("expect no hedging before enough samples")
+	}
+	h.observe(hedgeMinSamples * time.Millisecond)
+	if d, ok := h.delay(); !ok || d != 28*time.Millisecond {
+		t.Fatalf("delay = %v, %v, expect the 90th percentile", d, ok)
+	}
+
+	//delay 已经调用了 2 次，再调用 2 次攒够 1 次对冲的预算
+	if h.spend() {
+		t.Fatal("spend should fail without budget")
+	}
+	h.delay()
+	h.delay()
+	if !h.spend() || h.spend() {
+		t.Fatal("budget of 0.25 should allow one hedge every 4 requests")
+	}
+}
+
+func TestHedgeLocally(t *testing.T) {
+	slow := &slowPeer{delay: 5 * time.Second, canceled: make(chan struct{})}
+	g := newHedgedGroup(t, fixedPeer{slow}, 1)
+	start := time.Now()
+	v, err := g.Get("key")
+	if err != nil || v.String() != "local" {
+		t.Fatalf("Get = %q, %v", v.String(), err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("hedged Get took %v", d)
+	}
+	select {
+	case <-slow.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("slow peer request not canceled")
+	}
+	s := g.Stats()
+	if s.Hedges.Get() != 1 || s.HedgeWins.Get() != 1 || s.PeerErrors.Get() != 0 {
+		t.Fatalf("Hedges = %v, HedgeWins = %v, PeerErrors = %v", &s.Hedges, &s.HedgeWins, &s.PeerErrors)
+	}
+}
+
+func TestHedgeReplica(t *testing.T) {
+	slow := &slowPeer{delay: 5 * time.Second, canceled: make(chan struct{})}
+	fast := &slowPeer{delay: 0, value: "replica"}
+	g := newHedgedGroup(t, fixedReplicas{slow, fast}, 1)
+	v, err := g.Get("key")
+	if err != nil || v.String() != "replica" {
+		t.Fatalf("Get = %q, %v", v.String(), err)
+	}
+	<-slow.canceled
+	s := g.Stats()
+	if s.HedgeWins.Get() != 1 || s.PeerLoads.Get() != 1 || s.LocalLoads.Get() != 0 {
+		t.Fatalf("HedgeWins = %v, PeerLoads = %v, LocalLoads = %v", &s.HedgeWins, &s.PeerLoads, &s.LocalLoads)
+	}
+}
+
+func TestHedgeBudget(t *testing.T) {
+	peer := &slowPeer{delay: 20 * time.Millisecond, value: "peer"}
+	g := newHedgedGroup(t, fixedPeer{peer}, 0.01)
+	v, err := g.Get("key")
+	if err != nil || v.String() != "peer" {
+		t.Fatalf("Get = %q, %v", v.String(), err)
+	}
+	if s := g.Stats(); s.Hedges.Get() != 0 || s.HedgeSkips.Get() != 1 {
+		t.Fatalf("Hedges = %v, HedgeSkips = %v, expect the hedge over budget", &s.Hedges, &s.HedgeSkips)
+	}
+}
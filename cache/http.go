@@ -1,43 +1,222 @@
 package cache
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"go-tools/consistenthash"
 	pb "go-tools/gocachepb"
 	"google.golang.org/protobuf/proto"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
-	defaultBasePath = "/_gocache/"
-	defaultReplicas = 50
+	defaultBasePath     = "/_gocache/"
+	defaultReplicas     = 50
+	defaultPeerTimeout  = 5 * time.Second
+	defaultMaxIdleConns = 16
+	defaultCompressMin  = 1 << 10
+	defaultMaxSetBytes  = 64 << 20
+	defaultLeaveSuspend = time.Minute
 )
 
 var _ PeerGetter = (*httpGetter)(nil)
 var _ PeerPicker = (*HTTPPool)(nil)
+var _ ReplicaPicker = (*HTTPPool)(nil)
+var _ PeerSetter = (*httpGetter)(nil)
+var _ PeerDeleter = (*httpGetter)(nil)
+var _ PeerStreamer = (*httpGetter)(nil)
 
 type HTTPPool struct {
 	self        string
 	basePath    string
+	opts        HTTPPoolOptions
 	mu          sync.Mutex
 	peers       *consistenthash.Map    //一致性哈希算法的 Map
 	httpGetters map[string]*httpGetter //每一个远程节点对应一个 httpGetter
+	weights     map[string]int         //当前节点列表及权重
+	left        map[string]leftPeer    //主动退出、暂时不在哈希环上的节点
+	peersFile   *PeersFile
+	health      *peerHealth
+	client      *http.Client
+	signer      *requestSigner
+	ring        *ringCheck
+	done        chan struct{}
+	closeOnce   sync.Once
+	drain       drainer
+
+	prev          *consistenthash.Map //上一次变化前的哈希环，过渡期内用于找到 key 之前的 owner
+	prevUntil     time.Time
+	stopRebalance context.CancelFunc //取消正在进行的再平衡推送
+}
+
+// HTTPPoolOptions 是 HTTPPool 的可选配置，零值字段使用默认值
+type HTTPPoolOptions struct {
+	// BasePath 节点间通信的路径前缀，默认 "/_gocache/"
+	BasePath string
+	// Replicas 一致性哈希中每个节点的虚拟节点数，默认 50
+	Replicas int
+	// HashFn 一致性哈希函数，默认 crc32.ChecksumIEEE
+	HashFn consistenthash.Hash
+	// Registry ServeHTTP 查找 Group 的 Registry，默认 DefaultRegistry
+	Registry *Registry
+
+	// HealthCheckInterval 主动探测其他节点的间隔，0 表示只做被动检查
+	HealthCheckInterval time.Duration
+	// FailureThreshold 连续失败（连接错误、超时、502 或 504）多少次后摘除节点，默认 3。回源失败的 500 和过载的 503 不算
+	FailureThreshold int
+	// MinBackoff 和 MaxBackoff 是节点被摘除的时长范围，每次连续摘除时长翻倍，默认 1s 和 30s
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// ReplicationFactor 每个 key 的 owner 数量（主节点加副本），默认 1 即不复制
+	ReplicationFactor int
+
+	// Transport 访问其他节点使用的 http.RoundTripper，默认是按 MaxIdleConnsPerHost 配置的 http.Transport
+	Transport http.RoundTripper
+	// Timeout 单次请求的超时时间，默认 5s，小于 0 表示不超时。GetStream 只限制收到第一个 Chunk 之前的时间
+	Timeout time.Duration
+	// MaxIdleConnsPerHost 每个节点保留的空闲连接数，默认 16，只在 Transport 为 nil 时生效
+	MaxIdleConnsPerHost int
+	// MaxRetries GET 请求在连接错误、超时或 502/504 时的最大重试次数，默认 0 不重试，最多 10 次
+	MaxRetries int
+	// RetryBackoff 重试的基础等待时间，第 n 次重试随机等待 [0, RetryBackoff*2^(n-1))，区间上限最多增长到 5s，默认 50ms
+	RetryBackoff time.Duration
+
+	// TLS 不为 nil 时节点之间使用 mutual TLS：访问其他节点时出示证书并验证对方证书，
+	// ServeHTTP 拒绝非 TLS 请求，服务端需要使用 ServerTLSConfig 或 ListenAndServeTLS。
+	// 节点地址应为 https://，Transport 为自定义 RoundTripper 时需要自己配置 TLS
+	TLS CertProvider
+
+	// SigningSecrets 不为空时节点之间的请求使用 HMAC 签名，第一个密钥用于签名，全部用于验证，
+	// 运行中可以用 SetSigningSecrets 轮换
+	SigningSecrets [][]byte
+	// ReplayWindow 签名请求的时间戳与本机时间允许的最大偏差，默认 30s
+	ReplayWindow time.Duration
+
+	// MaxValueBytes 大于 0 时拒绝从其他节点取回或接收超过该长度的值，为 0 时其他节点推送的 PUT body 最多 64MB
+	MaxValueBytes int64
+
+	// CompressMinBytes 响应体不小于该长度且对方接受 gzip 时压缩后发送，默认 1KB，小于 0 表示不压缩
+	CompressMinBytes int
+
+	// HandoffKeys 大于 0 时 Shutdown 把每个 Group 最近访问的至多 HandoffKeys 条记录推送给接替本机的节点
+	HandoffKeys int
+	// LeaveSuspension 收到其他节点的退出通知后把它移出哈希环的最长时间，默认 1 分钟。
+	// 开启主动探测时，探测到节点重新启动后立即恢复
+	LeaveSuspension time.Duration
+
+	// RebalanceWindow 大于 0 时开启再平衡：Set 或 SetWeighted 改变哈希环后，本机把缓存中归属变化的记录推送给新的 owner，
+	// 并在 RebalanceWindow 内，本机新接手的 key 未命中时先读取之前的 owner 缓存中的值，再回源
+	RebalanceWindow time.Duration
+	// RebalanceRate 再平衡时每秒最多推送的记录数，默认 1000
+	RebalanceRate float64
 }
 
 type httpGetter struct {
 	baseUrl string //baseURL 表示将要访问的远程节点的地址
+	peer    string
+	report  func(peer string, err error) //汇报请求结果，用于被动健康检查
+	client  *http.Client
+	signer  *requestSigner
+	self    string
+	ring    *ringCheck
+	retry   Retry //GET 请求的重试策略
+	maxSize int64
+	timeout time.Duration //单次请求的超时时间，流式读取只限制等待响应头的时间
 }
 
+// errPeerTimeout 是单次请求超过 Timeout 时 ctx 的 cause，用于区分超时和调用方取消
+var errPeerTimeout = errors.New("gocache: peer request timeout")
+
 func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
-		self:     self,
-		basePath: defaultBasePath,
+	return NewHTTPPoolOpts(self, nil)
+}
+
+// NewHTTPPoolOpts 使用给定配置创建 HTTPPool，o 为 nil 时等同于 NewHTTPPool
+func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
+	p := &HTTPPool{self: self}
+	if o != nil {
+		p.opts = *o
+	}
+	if p.opts.BasePath == "" {
+		p.opts.BasePath = defaultBasePath
+	}
+	if p.opts.Replicas == 0 {
+		p.opts.Replicas = defaultReplicas
+	}
+	if p.opts.Registry == nil {
+		p.opts.Registry = DefaultRegistry
+	}
+	if p.opts.ReplicationFactor < 1 {
+		p.opts.ReplicationFactor = 1
+	}
+	if p.opts.Timeout == 0 {
+		p.opts.Timeout = defaultPeerTimeout
+	}
+	if p.opts.MaxIdleConnsPerHost == 0 {
+		p.opts.MaxIdleConnsPerHost = defaultMaxIdleConns
+	}
+	retry := NewRetry(p.opts.MaxRetries, p.opts.RetryBackoff)
+	p.opts.MaxRetries, p.opts.RetryBackoff = retry.MaxRetries, retry.Backoff
+	if p.opts.CompressMinBytes == 0 {
+		p.opts.CompressMinBytes = defaultCompressMin
 	}
+	if p.opts.RebalanceRate <= 0 {
+		p.opts.RebalanceRate = defaultRebalanceRate
+	}
+	if p.opts.ReplayWindow <= 0 {
+		p.opts.ReplayWindow = defaultReplayWindow
+	}
+	if p.opts.LeaveSuspension <= 0 {
+		p.opts.LeaveSuspension = defaultLeaveSuspend
+	}
+	p.basePath = p.opts.BasePath
+	p.client = newPeerClient(&p.opts)
+	p.signer = newRequestSigner(p.opts.SigningSecrets, p.opts.ReplayWindow)
+	p.ring = &ringCheck{log: p.Log}
+	p.health = newPeerHealth(&p.opts)
+	p.done = make(chan struct{})
+	if p.opts.HealthCheckInterval > 0 {
+		go p.probeLoop(p.opts.HealthCheckInterval)
+	}
+	return p
+}
+
+// newPeerClient 创建访问其他节点的 http.Client，所有 httpGetter 共用它的连接池
+func newPeerClient(o *HTTPPoolOptions) *http.Client {
+	transport := o.Transport
+	if transport == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+		transport = t
+	}
+	//不设置 http.Client.Timeout，它会把流式读取的响应体一起截断，超时由每个请求的 ctx 控制
+	return &http.Client{Transport: peerTransport(transport, o.TLS)}
+}
+
+// Close 停止主动健康检查，并关闭到其他节点的空闲连接
+func (p *HTTPPool) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.mu.Lock()
+		if p.stopRebalance != nil {
+			p.stopRebalance()
+		}
+		p.mu.Unlock()
+		p.client.CloseIdleConnections()
+	})
+	return nil
 }
 
 func (p *HTTPPool) Log(format string, v ...interface{}) {
@@ -50,7 +229,24 @@ func (p *HTTPPool) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 		panic("HTTPPool serving unexpected path: " + request.URL.Path)
 	}
 	p.Log("%s %s %s", "Receive Request:", request.Method, request.URL.Path)
+	if p.opts.TLS != nil && request.TLS == nil {
+		http.Error(writer, "TLS required", http.StatusForbidden)
+		return
+	}
+	if !p.drain.enter() { //Shutdown 之后健康检查也返回 503
+		http.Error(writer, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer p.drain.leave()
 	// /<basepath>/<groupname>/<key> required
+	switch request.URL.Path[len(p.basePath):] {
+	case healthPath:
+		writer.Write([]byte("ok"))
+		return
+	case leavePath:
+		p.serveLeave(writer, request)
+		return
+	}
 	parts := strings.SplitN(request.URL.Path[len(p.basePath):], "/", 2)
 	if len(parts) != 2 {
 		http.Error(writer, "bad request", http.StatusBadRequest)
@@ -60,54 +256,277 @@ func (p *HTTPPool) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 	groupName := parts[0]
 	key := parts[1]
 
-	group := GetGroup(groupName)
+	var payload []byte //PUT 的 body 参与签名，需要先读出来
+	if request.Method == http.MethodPut {
+		if err := p.signer.precheck(request); err != nil {
+			p.Log("reject request %s %s: %v", request.Method, request.URL.Path, err)
+			http.Error(writer, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var err error
+		if payload, err = ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, p.maxSetBytes())); err != nil {
+			code := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				code = http.StatusRequestEntityTooLarge
+			}
+			http.Error(writer, err.Error(), code)
+			return
+		}
+	}
+	if err := p.signer.verify(request, payload); err != nil {
+		p.Log("reject request %s %s: %v", request.Method, request.URL.Path, err)
+		http.Error(writer, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	ctx := request.Context()
+	if from := request.Header.Get(forwardedByHeader); from != "" { //其他节点转发来的请求总是在本机处理
+		ctx = withLocalOnly(ctx)
+		p.ring.observe(from, request.Header.Get(ringVersionHeader))
+	}
+	writer.Header().Set(ringVersionHeader, p.ring.header())
+
+	group := p.opts.Registry.GetGroup(groupName)
 	if group == nil {
 		http.Error(writer, "no such group : "+groupName, http.StatusNotFound)
 		return
 	}
 
-	view, err := group.Get(key)
+	if request.Method == http.MethodPut {
+		p.serveSet(writer, payload, group, key)
+		return
+	}
+	if request.Method == http.MethodDelete {
+		group.removeLocally(key)
+		body, _ := proto.Marshal(&pb.DeleteResponse{})
+		writer.Header().Set("Content-Type", "application/octet-stream")
+		writer.Write(body)
+		return
+	}
+
+	var view ByteView
+	var err error
+	if request.Header.Get(cacheOnlyHeader) != "" { //过渡期内新的 owner 只读取本机缓存中的值
+		view, err = group.peek(key)
+	} else {
+		view, err = group.GetContext(ctx, key)
+	}
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrOriginOverloaded) {
+			code = http.StatusServiceUnavailable
+		} else if errors.Is(err, ErrNotFound) {
+			code = http.StatusNotFound
+		}
+		http.Error(writer, err.Error(), code)
 		return
 	}
 
 	//writer.Header().Set("Content-Type", "application/octet-stream")
 	//writer.Write(view.ByteSlice())
 	// Write the value to the response body as a proto message.
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()}) //ServeHTTP() 中使用 proto.Marshal() 编码 HTTP 响应。
+	gz := p.opts.CompressMinBytes >= 0 && acceptsGzip(request.Header.Get("Accept-Encoding"))
+	if acceptsChunks(request.Header.Get("Accept")) && view.Len() > streamChunkSize { //大的值分块传输，不需要再序列化一份完整的副本
+		writer.Header().Set("Content-Type", streamContentType)
+		p.serveChunks(writer, group, view, gz && view.Len() >= p.opts.CompressMinBytes && streamCompressible(view))
+		return
+	}
+	body, err := proto.Marshal(view.response()) //ServeHTTP() 中使用 proto.Marshal() 编码 HTTP 响应。
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Vary", "Accept-Encoding")
+	if gz && len(body) >= p.opts.CompressMinBytes {
+		if z, err := gzipBytes(body); err == nil && len(z) < len(body) { //压缩后没有变小的原样发送
+			writer.Header().Set("Content-Encoding", "gzip")
+			group.stats.PeerBytesSaved.Add(int64(len(body) - len(z)))
+			body = z
+		}
+	}
+	group.stats.PeerBytesSent.Add(int64(len(body)))
+	writer.Write(body)
+}
+
+// serveChunks 分块写出 view，gz 为 true 时整个流用 gzip 压缩。流式写出时无法先比较压缩前后的大小，
+// 由调用方用 streamCompressible 判断是否值得压缩
+func (p *HTTPPool) serveChunks(writer http.ResponseWriter, group *Group, view ByteView, gz bool) {
+	sent := &countingWriter{w: writer}
+	var err error
+	if gz {
+		writer.Header().Set("Content-Encoding", "gzip")
+		raw := &countingWriter{}
+		err = gzipTo(sent, func(w io.Writer) error {
+			raw.w = w
+			return writeChunks(raw, view)
+		})
+		if raw.n > sent.n { //试压缩的第一块不代表整个值，压缩后仍可能变大
+			group.stats.PeerBytesSaved.Add(raw.n - sent.n)
+		}
+	} else {
+		err = writeChunks(sent, view)
+	}
+	group.stats.PeerBytesSent.Add(sent.n)
+	if err != nil {
+		p.Log("stream %s value of %d bytes error: %v", group.name, view.Len(), err)
+	}
+}
+
+// serveSet 处理其他节点推送来的副本
+func (p *HTTPPool) serveSet(writer http.ResponseWriter, payload []byte, group *Group, key string) {
+	in := &pb.SetRequest{}
+	if err := proto.Unmarshal(payload, in); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := group.setLocally(key, viewOf(in.GetValue(), in.GetExpire(), in.GetContentType())); err != nil {
+		http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	body, _ := proto.Marshal(&pb.SetResponse{})
 	writer.Header().Set("Content-Type", "application/octet-stream")
 	writer.Write(body)
 }
 
+// maxSetBytes 返回 PUT 请求 body 的长度上限：MaxValueBytes 加上 pb.SetRequest 其他字段的空间，没有配置时为 defaultMaxSetBytes
+func (p *HTTPPool) maxSetBytes() int64 {
+	if p.opts.MaxValueBytes > 0 {
+		return p.opts.MaxValueBytes + 1024
+	}
+	return defaultMaxSetBytes
+}
+
 func (p *HTTPPool) Set(peers ...string) {
+	weights := make(map[string]int, len(peers))
+	for _, peer := range peers {
+		weights[peer] = 1
+	}
+	p.SetWeighted(weights)
+}
+
+// SetWeighted 更新节点列表及权重（虚拟节点数为 Replicas*weight）。只修改哈希环上有变化的节点，
+// 未变化节点的 httpGetter 保持不变，正在进行的请求不受影响。之前退出的节点按新的列表恢复
+func (p *HTTPPool) SetWeighted(weights map[string]int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.peers = consistenthash.New(defaultReplicas, nil)
-	p.peers.Add(peers...) //节点初始化
-	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	p.left = nil
+	p.setWeighted(weights)
+}
+
+func (p *HTTPPool) setWeighted(weights map[string]int) {
+	var prev *consistenthash.Map
+	if p.peers != nil && p.opts.RebalanceWindow > 0 {
+		prev = newRing(p.opts.Replicas, p.opts.HashFn, p.weights)
+	}
+	version := ringVersion(p.weights)
+	if p.peers == nil {
+		p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+		p.weights = make(map[string]int)
+		p.httpGetters = make(map[string]*httpGetter)
+	}
+
+	var removed []string
+	for peer, w := range p.weights {
+		if nw, ok := weights[peer]; !ok || nw != w {
+			removed = append(removed, peer)
+		}
+	}
+	p.peers.Remove(removed...)
+	for _, peer := range removed {
+		delete(p.weights, peer)
+		if _, ok := weights[peer]; !ok {
+			delete(p.httpGetters, peer)
+		}
+	}
+
+	peers := make([]string, 0, len(weights))
+	for peer := range weights {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers) //按固定顺序添加，保证各节点的哈希环一致
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{
-			baseUrl: peer + p.basePath,
+		if _, ok := p.weights[peer]; ok {
+			continue
+		}
+		w := weights[peer]
+		if w < 1 {
+			w = 1
 		}
+		p.peers.AddWeighted(peer, w) //节点初始化
+		p.weights[peer] = weights[peer]
+		if _, ok := p.httpGetters[peer]; !ok {
+			p.httpGetters[peer] = &httpGetter{
+				baseUrl: peer + p.basePath,
+				peer:    peer,
+				report:  p.report,
+				client:  p.client,
+				signer:  p.signer,
+				self:    p.self,
+				ring:    p.ring,
+				retry:   Retry{MaxRetries: p.opts.MaxRetries, Backoff: p.opts.RetryBackoff},
+				maxSize: p.opts.MaxValueBytes,
+				timeout: p.opts.Timeout,
+			}
+		}
+	}
+	p.health.retain(peers)
+	p.ring.set(ringVersion(p.weights))
+	if prev != nil && ringVersion(p.weights) != version {
+		p.startRebalance(prev)
 	}
 }
 
+// PickPeer 选择 key 的 owner。owner 不健康时沿哈希环选择下一个不同的节点，
+// 轮到本机节点或所有节点都不健康时返回 false，由调用方在本地加载
 func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if peer := p.peers.Get(key); peer != "" && peer != p.self {
-		p.Log("Ready to Pick peer %s", peer)
-		return p.httpGetters[peer], true
+	if p.peers == nil {
+		return nil, false
+	}
+	p.rejoinExpired()
+	for _, peer := range p.peers.GetN(key, len(p.httpGetters)) {
+		if peer == p.self {
+			return nil, false
+		}
+		if p.health.healthy(peer) {
+			p.Log("Ready to Pick peer %s", peer)
+			return p.httpGetters[peer], true
+		}
+		p.Log("Skip unhealthy peer %s", peer)
 	}
 	return nil, false
 }
 
+// PickReplicas 沿哈希环跳过不健康的远程节点，返回 key 的前 ReplicationFactor 个 owner，本机节点总是保留。
+// 和 PickPeer 一样，owner 不健康时由环上的下一个节点接替
+func (p *HTTPPool) PickReplicas(key string) ([]PeerGetter, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, -1
+	}
+	p.rejoinExpired()
+	self := -1
+	owners := make([]PeerGetter, 0, p.opts.ReplicationFactor)
+	for _, peer := range p.peers.GetN(key, len(p.httpGetters)) {
+		if len(owners) == p.opts.ReplicationFactor {
+			break
+		}
+		if peer == p.self {
+			self = len(owners)
+			owners = append(owners, nil)
+		} else if p.health.healthy(peer) {
+			owners = append(owners, p.httpGetters[peer])
+		}
+	}
+	return owners, self
+}
+
 //func (h *httpGetter) Get(group string, key string) ([]byte, error) {
 //	u := fmt.Sprintf("%v%v/%v", h.baseUrl, url.QueryEscape(group), url.QueryEscape(key))
 //
@@ -131,30 +550,222 @@ func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 
 //Get 将 HTTP 通信的中间载体替换成了 protobuf
 func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
+	return h.do(context.Background(), http.MethodGet, in.GetGroup(), in.GetKey(), nil, out)
+}
+
+// GetContext 同 Get，ctx 被取消时中断请求和重试
+func (h *httpGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return h.do(ctx, http.MethodGet, in.GetGroup(), in.GetKey(), nil, out)
+}
+
+// Set 把值推送到远程节点的缓存
+func (h *httpGetter) Set(in *pb.SetRequest, out *pb.SetResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return h.do(context.Background(), http.MethodPut, in.GetGroup(), in.GetKey(), body, out)
+}
+
+// set 同 Set，ctx 被取消时中断请求
+func (h *httpGetter) set(ctx context.Context, in *pb.SetRequest) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return h.do(ctx, http.MethodPut, in.GetGroup(), in.GetKey(), body, &pb.SetResponse{})
+}
+
+// Delete 删除远程节点缓存中的 key
+func (h *httpGetter) Delete(ctx context.Context, in *pb.DeleteRequest) error {
+	return h.do(ctx, http.MethodDelete, in.GetGroup(), in.GetKey(), nil, &pb.DeleteResponse{})
+}
+
+// do 发送请求，GET 请求在可重试的错误后按 retry 重试，PUT 不重试
+func (h *httpGetter) do(ctx context.Context, method, group, key string, body []byte, out proto.Message) error {
 	u := fmt.Sprintf(
 		"%v%v/%v",
 		h.baseUrl,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
+		url.QueryEscape(group),
+		url.QueryEscape(key),
 	)
-	res, err := http.Get(u)
+	retry := Retry{}
+	if method == http.MethodGet {
+		retry = h.retry
+	}
+	return retry.Do(ctx, func() (bool, error) {
+		return h.try(ctx, method, u, body, out)
+	}, func(wait time.Duration, err error) {
+		log.Printf("[gocache] retry %s %s in %v after: %v \n", method, u, wait, err)
+	})
+}
+
+// try 发送一次请求，retry 表示失败的请求是否可以重试
+func (h *httpGetter) try(ctx context.Context, method, u string, body []byte, out proto.Message) (retry bool, err error) {
+	ctx, cancel := h.withTimeout(ctx)
+	defer cancel()
+	res, retry, err := h.send(ctx, method, u, body)
 	if err != nil {
-		return err
+		return retry, err
 	}
 	defer res.Body.Close()
 
+	if res.Header.Get("Content-Type") == streamContentType {
+		resp, ok := out.(*pb.Response)
+		if !ok {
+			return false, fmt.Errorf("unexpected chunked response to %s", method)
+		}
+		if err := readChunks(res.Body, resp, h.maxSize); err != nil {
+			return !errors.Is(err, ErrValueTooLarge), fmt.Errorf("reading chunks: %w", err)
+		}
+		return false, nil
+	}
+	bytes, err := h.readBody(res.Body)
+	if err != nil {
+		return !errors.Is(err, ErrValueTooLarge), err
+	}
+
+	if err = proto.Unmarshal(bytes, out); err != nil { //Get() 中使用 proto.Unmarshal() 解码 HTTP 响应
+		return false, fmt.Errorf("decoding response body: %v", err)
+	}
+	log.Printf("HTTP %s %s response of %d bytes \n", method, u, len(bytes))
+	return false, nil
+}
+
+// send 发送请求并检查状态码，返回 200 的响应，retry 表示失败的请求是否可以重试
+func (h *httpGetter) send(ctx context.Context, method, u string, body []byte) (res *http.Response, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytesReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set(forwardedByHeader, h.self)
+	req.Header.Set(ringVersionHeader, h.ring.header())
+	if isCacheOnly(ctx) {
+		req.Header.Set(cacheOnlyHeader, "1")
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Accept", streamContentType)
+		req.Header.Set("Accept-Encoding", "gzip") //显式设置后 http.Transport 不再自动解压，由 send 解压
+	}
+	if err := h.signer.sign(req, body); err != nil {
+		return nil, false, err
+	}
+	res, err = h.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil && context.Cause(ctx) != errPeerTimeout { //被调用方取消，不是对方的问题
+			return nil, false, err
+		}
+		h.report(h.peer, err)
+		return nil, true, err
+	}
+	h.ring.observe(h.peer, res.Header.Get(ringVersionHeader))
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		res.Body.Close()
+		err = fmt.Errorf("server returned: %v", res.Status)
+		//500 通常是回源失败，503 是对方回源过载，对方节点本身是正常的，不计入健康检查，也不重试
+		retry = RetryableStatus(res.StatusCode)
+		if retry {
+			h.report(h.peer, err)
+		}
+		return nil, retry, err
+	}
+	h.report(h.peer, nil)
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, false, fmt.Errorf("server returned: %v: %w", res.Status, ErrNotFound)
+	}
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+		res.Body.Close()
+		return nil, false, fmt.Errorf("server returned: %v", res.Status)
 	}
+	if res.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			res.Body.Close()
+			return nil, true, fmt.Errorf("reading gzip response: %v", err)
+		}
+		res.Body = &gzipReadCloser{Reader: zr, body: res.Body}
+	}
+	return res, false, nil
+}
 
-	bytes, err := ioutil.ReadAll(res.Body)
+// readBody 读取非分块的响应体，超过 maxSize 时返回 ErrValueTooLarge
+func (h *httpGetter) readBody(r io.Reader) ([]byte, error) {
+	if h.maxSize > 0 {
+		r = io.LimitReader(r, h.maxSize+1024) //留出 pb.Response 其他字段的空间
+	}
+	b, err := ioutil.ReadAll(r)
 	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+		return nil, fmt.Errorf("reading response body: %v", err)
+	}
+	if h.maxSize > 0 && int64(len(b)) > h.maxSize+1024 {
+		return nil, fmt.Errorf("%w: response of more than %d bytes", ErrValueTooLarge, h.maxSize)
 	}
+	return b, nil
+}
 
-	if err = proto.Unmarshal(bytes, out); err != nil { //Get() 中使用 proto.Unmarshal() 解码 HTTP 响应
-		return fmt.Errorf("decoding response body: %v", err)
+// GetStream 以流的方式读取远程节点上的值，值较大时对方分块传输，读完最后一块后校验完整性。不重试
+func (h *httpGetter) GetStream(ctx context.Context, in *pb.Request) (io.ReadCloser, error) {
+	u := fmt.Sprintf("%v%v/%v", h.baseUrl, url.QueryEscape(in.GetGroup()), url.QueryEscape(in.GetKey()))
+	//Timeout 只限制收到响应头和第一个 Chunk 的时间，之后的传输由调用方的 ctx 控制
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := func() bool { return true }
+	if h.timeout > 0 {
+		stop = time.AfterFunc(h.timeout, func() { cancel(errPeerTimeout) }).Stop
 	}
-	log.Printf("HTTP Get response data = %v \n", string(bytes))
-	return nil
+	res, _, err := h.send(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	if res.Header.Get("Content-Type") == streamContentType {
+		c := newChunkReader(res.Body, h.maxSize)
+		if _, err := c.header(); err != nil && err != io.EOF {
+			res.Body.Close()
+			cancel(nil)
+			return nil, err
+		}
+		stop()
+		return &chunkReadCloser{chunkReader: c, Closer: cancelCloser{res.Body, cancel}}, nil
+	}
+	defer cancel(nil)
+	defer res.Body.Close()
+	b, err := h.readBody(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	out := &pb.Response{}
+	if err := proto.Unmarshal(b, out); err != nil {
+		return nil, fmt.Errorf("decoding response body: %v", err)
+	}
+	return io.NopCloser(bytes.NewReader(out.GetValue())), nil
+}
+
+// withTimeout 为一次请求加上 Timeout，超时后 ctx 的 cause 为 errPeerTimeout
+func (h *httpGetter) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, h.timeout, errPeerTimeout)
+}
+
+// cancelCloser 关闭响应体后取消请求的 ctx
+type cancelCloser struct {
+	io.Closer
+	cancel context.CancelCauseFunc
+}
+
+func (c cancelCloser) Close() error {
+	err := c.Closer.Close()
+	c.cancel(nil)
+	return err
+}
+
+// bytesReader 在 body 为 nil 时返回 nil，使 GET 请求不带 body
+func bytesReader(body []byte) io.Reader {
+	if body == nil {
+		return nil
+	}
+	return bytes.NewReader(body)
 }
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "go-tools/gocachepb"
+)
+
+func newClientTestPool(t *testing.T, o HTTPPoolOptions, handler http.HandlerFunc) (*HTTPPool, PeerGetter) {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	pool := NewHTTPPoolOpts("self", &o)
+	t.Cleanup(func() { pool.Close() })
+	pool.Set(srv.URL)
+	peer, ok := pool.PickPeer("key")
+	if !ok {
+		t.Fatal("expect a remote peer")
+	}
+	return pool, peer
+}
+
+func TestPeerRetry(t *testing.T) {
+	r := NewRegistry()
+	r.NewGroup("retry", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	serve := NewHTTPPoolOpts("", &HTTPPoolOptions{Registry: r})
+	var calls, failures int32 = 0, 2
+	_, peer := newClientTestPool(t, HTTPPoolOptions{MaxRetries: 2, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= atomic.LoadInt32(&failures) {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		serve.ServeHTTP(w, req)
+	})
+
+	out := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "retry", Key: "key"}, out); err != nil || string(out.GetValue()) != "key" {
+		t.Fatalf("Get = %q, %v", out.GetValue(), err)
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d, expect 3", calls)
+	}
+
+	// 超过重试次数后返回最后一次的错误
+	atomic.StoreInt32(&calls, 0)
+	atomic.StoreInt32(&failures, 10)
+	if err := peer.Get(&pb.Request{Group: "retry", Key: "key"}, out); err == nil {
+		t.Fatal("expect error after retries are exhausted")
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d, expect 1 attempt and 2 retries", calls)
+	}
+}
+
+func TestPeerNoRetry(t *testing.T) {
+	var calls int32
+	_, peer := newClientTestPool(t, HTTPPoolOptions{MaxRetries: 3, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "origin failed", http.StatusInternalServerError)
+	})
+	if err := peer.Get(&pb.Request{Group: "retry", Key: "key"}, &pb.Response{}); err == nil {
+		t.Fatal("expect error")
+	}
+	if calls != 1 {
+		t.Fatalf("500 should not be retried, calls = %d", calls)
+	}
+
+	// PUT 不是幂等的，不重试
+	atomic.StoreInt32(&calls, 0)
+	if err := peer.(PeerSetter).Set(&pb.SetRequest{Group: "retry", Key: "key"}, &pb.SetResponse{}); err == nil {
+		t.Fatal("expect error")
+	}
+	if calls != 1 {
+		t.Fatalf("PUT should not be retried, calls = %d", calls)
+	}
+}
+
+func TestPeerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	var calls int32
+	_, peer := newClientTestPool(t, HTTPPoolOptions{Timeout: 20 * time.Millisecond, MaxRetries: 1, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	})
+
+	start := time.Now()
+	if err := peer.Get(&pb.Request{Group: "retry", Key: "key"}, &pb.Response{}); err == nil {
+		t.Fatal("expect timeout error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Get took %v, expect the request timeout to stop it", d)
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("calls = %d, timed out request should be retried once", calls)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                   false,
+		"gzip":               true,
+		"br, gzip;q=0.5":     true,
+		"gzip;q=0":           false,
+		"deflate, identity":  false,
+		"x-gzip, gzip ; q=1": true,
+	} {
+		if got := acceptsGzip(accept); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, expect %v", accept, got, want)
+		}
+	}
+}
+
+func TestPeerGzip(t *testing.T) {
+	nodes := newTestCluster(t, 2, HTTPPoolOptions{CompressMinBytes: 100})
+	a, b := nodes[0], nodes[1]
+	keyOn := func(prefix string) string {
+		for i := 0; ; i++ {
+			key := fmt.Sprint(prefix, i)
+			if peer, ok := a.pool.PickPeer(key); ok && peer.(*httpGetter).peer == b.url() {
+				return key
+			}
+		}
+	}
+	small, medium, large, random := keyOn("small"), keyOn("medium"), keyOn("large"), keyOn("random")
+	noise := make([]byte, 300<<10)
+	rand.Read(noise)
+	values := map[string][]byte{
+		small:  []byte("tiny"),
+		medium: bytes.Repeat([]byte("abcd"), 1000),
+		large:  bytes.Repeat([]byte("0123456789"), 30<<10), //超过 streamChunkSize，分块后整体压缩
+		random: noise,                                      //无法压缩，分块后原样发送
+	}
+	for key, v := range values {
+		if err := b.group.set(key, ByteView{b: v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(key string) string {
+		req, _ := http.NewRequest("GET", b.url()+defaultBasePath+"group/"+key, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("Accept", streamContentType)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.Header.Get("Content-Encoding")
+	}
+	if ce := get(small); ce != "" {
+		t.Fatalf("value below CompressMinBytes sent with Content-Encoding %q", ce)
+	}
+	if ce := get(medium); ce != "gzip" {
+		t.Fatalf("Content-Encoding = %q, expect gzip", ce)
+	}
+	if ce := get(random); ce != "" {
+		t.Fatalf("incompressible stream sent with Content-Encoding %q", ce)
+	}
+
+	for key, v := range values {
+		got, err := a.group.Get(key)
+		if err != nil || !bytes.Equal(got.b, v) {
+			t.Fatalf("Get(%s) = %d bytes, %v", key, got.Len(), err)
+		}
+	}
+	stats := b.group.Stats()
+	if stats.PeerBytesSaved.Get() <= int64(len(values[large])/2) || stats.PeerBytesSent.Get() == 0 {
+		t.Fatalf("PeerBytesSent = %d, PeerBytesSaved = %d", stats.PeerBytesSent.Get(), stats.PeerBytesSaved.Get())
+	}
+}
+
+func TestStreamCompressible(t *testing.T) {
+	text := bytes.Repeat([]byte("0123456789"), 30<<10)
+	noise := make([]byte, len(text))
+	rand.Read(noise)
+	for _, c := range []struct {
+		view ByteView
+		want bool
+	}{
+		{ByteView{b: text}, true},
+		{ByteView{b: noise}, false},
+		{ByteView{b: text, ct: "image/png"}, false},
+		{ByteView{b: text, ct: "text/plain"}, true},
+	} {
+		if got := streamCompressible(c.view); got != c.want {
+			t.Errorf("streamCompressible(%d bytes, %q) = %v, expect %v", c.view.Len(), c.view.ct, got, c.want)
+		}
+	}
+}
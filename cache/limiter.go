@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOriginOverloaded 表示回源请求的排队数已满
+var ErrOriginOverloaded = errors.New("gocache: origin overloaded")
+
+// originLimiter 限制调用 Getter 的并发数和速率，排队的请求遵守 context 的超时
+type originLimiter struct {
+	sem      chan struct{} //为 nil 时不限制并发
+	maxQueue int32
+	queued   int32
+	bucket   *tokenBucket //为 nil 时不限速
+}
+
+func newOriginLimiter(maxInFlight, maxQueue int, rate float64, burst int) *originLimiter {
+	l := &originLimiter{maxQueue: int32(maxQueue)}
+	if maxInFlight > 0 {
+		l.sem = make(chan struct{}, maxInFlight)
+	}
+	if rate > 0 {
+		l.bucket = newTokenBucket(rate, burst, maxQueue)
+	}
+	return l
+}
+
+// acquire 等待回源许可，成功后必须调用 release。先取令牌再占并发名额，等待令牌的请求不占用并发名额
+func (l *originLimiter) acquire(ctx context.Context) (release func(), err error) {
+	release = func() {}
+	if l.bucket != nil {
+		if err := l.bucket.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		default:
+			if atomic.AddInt32(&l.queued, 1) > l.maxQueue {
+				atomic.AddInt32(&l.queued, -1)
+				l.refund()
+				return nil, ErrOriginOverloaded
+			}
+			select {
+			case l.sem <- struct{}{}:
+				atomic.AddInt32(&l.queued, -1)
+			case <-ctx.Done():
+				atomic.AddInt32(&l.queued, -1)
+				l.refund()
+				return nil, ctx.Err()
+			}
+		}
+		release = func() { <-l.sem }
+	}
+	return release, nil
+}
+
+// refund 归还 acquire 取走但没有用于回源的令牌
+func (l *originLimiter) refund() {
+	if l.bucket != nil {
+		l.bucket.refund()
+	}
+}
+
+// tokenBucket 是一个简单的令牌桶，每秒补充 rate 个令牌，最多积攒 burst 个。
+// 令牌不足时最多预支 maxDebt 个，即最多等待 maxDebt/rate，超出时拒绝
+type tokenBucket struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	maxDebt float64
+	tokens  float64
+	last    time.Time
+	now     func() time.Time
+}
+
+// newTokenBucket 创建令牌桶，maxDebt 小于 1 时使用 burst
+func newTokenBucket(rate float64, burst, maxDebt int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	if maxDebt < 1 {
+		maxDebt = burst
+	}
+	return &tokenBucket{
+		rate:    rate,
+		burst:   float64(burst),
+		maxDebt: float64(maxDebt),
+		tokens:  float64(burst),
+		last:    time.Now(),
+		now:     time.Now,
+	}
+}
+
+// reserve 取走一个令牌，令牌不足时预支，返回需要等待的时间；预支超过 maxDebt 时不取令牌并返回 false
+func (b *tokenBucket) reserve() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens <= -b.maxDebt { //已经预支了 maxDebt 个令牌
+		return 0, false
+	}
+	b.tokens--
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), true
+}
+
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	b.tokens++
+	b.mu.Unlock()
+}
+
+// wait 取走一个令牌，令牌不足时预支并等待补充，ctx 先结束则归还令牌。预支太多时返回 ErrOriginOverloaded
+func (b *tokenBucket) wait(ctx context.Context) error {
+	delay, ok := b.reserve()
+	if !ok {
+		return ErrOriginOverloaded
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.refund()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOriginLimit(t *testing.T) {
+	block, entered := make(chan struct{}), make(chan struct{}, 1)
+	g, err := NewRegistry().NewGroupOpts("origin-limit", GetterFunc(func(key string) ([]byte, error) {
+		entered <- struct{}{}
+		<-block
+		return []byte(key), nil
+	}), WithOriginLimit(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { //占住唯一的回源名额
+		defer wg.Done()
+		g.Get("a")
+	}()
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() { //排队，直到 ctx 超时
+		_, err := g.GetContext(ctx, "b")
+		errs <- err
+	}()
+	for atomic.LoadInt32(&g.limiter.queued) == 0 {
+		runtime.Gosched()
+	}
+
+	if _, err := g.Get("c"); err != ErrOriginOverloaded {
+		t.Fatalf("expect ErrOriginOverloaded when queue is full, got %v", err)
+	}
+	if err := <-errs; err != context.DeadlineExceeded {
+		t.Fatalf("queued caller should honor deadline, got %v", err)
+	}
+	close(block)
+	wg.Wait()
+	if v, err := g.Get("c"); err != nil || v.String() != "c" {
+		t.Fatalf("Get c = %q, %v", v.String(), err)
+	}
+	if n := g.Stats().OriginRejects.Get(); n != 1 {
+		t.Fatalf("OriginRejects = %d, expect 1", n)
+	}
+}
+
+func TestOriginRateLimit(t *testing.T) {
+	g, err := NewRegistry().NewGroupOpts("origin-rate", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithOriginRateLimit(100, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	g.limiter.bucket.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		if _, err := g.Get(fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//burst 2 个立即通过，之后每个令牌需要 10ms
+	if d, _ := g.limiter.bucket.reserve(); d != 10*time.Millisecond {
+		t.Fatalf("third token wait = %v, expect 10ms", d)
+	}
+	now = now.Add(5 * time.Millisecond)
+	if d, _ := g.limiter.bucket.reserve(); d != 15*time.Millisecond {
+		t.Fatalf("fourth token wait = %v, expect 15ms", d)
+	}
+
+	slow, _ := NewRegistry().NewGroupOpts("origin-rate-slow", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithOriginRateLimit(1, 1))
+	slow.Get("x")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := slow.GetContext(ctx, "y"); err != context.DeadlineExceeded {
+		t.Fatalf("expect deadline exceeded while waiting for a token, got %v", err)
+	}
+}
+
+func TestOriginRateDebt(t *testing.T) {
+	g, _ := NewRegistry().NewGroupOpts("origin-debt", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithOriginLimit(1, 2), WithOriginRateLimit(1, 1))
+	now := time.Now()
+	g.limiter.bucket.now = func() time.Time { return now }
+	if _, err := g.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	//等待令牌的请求不占用并发名额
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	for _, key := range []string{"b", "c"} {
+		go func(key string) {
+			_, err := g.GetContext(ctx, key)
+			errs <- err
+		}(key)
+	}
+	for {
+		g.limiter.bucket.mu.Lock()
+		tokens := g.limiter.bucket.tokens
+		g.limiter.bucket.mu.Unlock()
+		if tokens <= -2 {
+			break
+		}
+		runtime.Gosched()
+	}
+	if n := len(g.limiter.sem); n != 0 {
+		t.Fatalf("%d in-flight slots held while waiting for tokens", n)
+	}
+
+	//预支的令牌达到 maxQueue 后直接拒绝，不再无限排队
+	if _, err := g.Get("d"); err != ErrOriginOverloaded {
+		t.Fatalf("expect ErrOriginOverloaded beyond the token debt, got %v", err)
+	}
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != context.Canceled {
+			t.Fatalf("queued caller = %v, expect canceled", err)
+		}
+	}
+	if _, ok := g.limiter.bucket.reserve(); !ok {
+		t.Fatal("canceled callers should return their tokens")
+	}
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"go-tools/membership"
+)
+
+// ringPeers 返回 p 的哈希环上的节点，按地址排序
+func ringPeers(p *HTTPPool) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]string, 0, len(p.weights))
+	for peer := range p.weights {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+func TestMembershipRing(t *testing.T) {
+	pools := make([]*HTTPPool, 3)
+	nodes := make([]*membership.Node, 3)
+	for i := range nodes {
+		addr := fmt.Sprintf("http://cache-%d", i)
+		pools[i] = NewHTTPPoolOpts(addr, &HTTPPoolOptions{Registry: NewRegistry()})
+		node, err := membership.Create(membership.Config{
+			Meta:             addr,
+			ProbeInterval:    30 * time.Millisecond,
+			ProbeTimeout:     10 * time.Millisecond,
+			SuspicionTimeout: 100 * time.Millisecond,
+			OnChange:         membership.SetPeers(pools[i].Set),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = node
+	}
+	t.Cleanup(func() {
+		for i := range nodes {
+			nodes[i].Shutdown()
+			pools[i].Close()
+		}
+	})
+
+	waitRing := func(live []int, expect ...string) {
+		deadline := time.Now().Add(3 * time.Second)
+		for _, i := range live {
+			for fmt.Sprint(ringPeers(pools[i])) != fmt.Sprint(expect) {
+				if time.Now().After(deadline) {
+					t.Fatalf("pool %d ring = %v, expect %v", i, ringPeers(pools[i]), expect)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+	}
+
+	// 加入集群后所有节点的哈希环一致
+	for _, node := range nodes[1:] {
+		if err := node.Join(nodes[0].Name()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitRing([]int{0, 1, 2}, "http://cache-0", "http://cache-1", "http://cache-2")
+
+	// 节点宕机后被确认死亡，从其余节点的哈希环中去掉
+	nodes[2].Shutdown()
+	waitRing([]int{0, 1}, "http://cache-0", "http://cache-1")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		p0, ok0 := pools[0].PickPeer(key)
+		p1, ok1 := pools[1].PickPeer(key)
+		switch {
+		case ok0 && p0.(*httpGetter).peer != "http://cache-1",
+			ok1 && p1.(*httpGetter).peer != "http://cache-0",
+			ok0 == ok1:
+			t.Fatalf("pools disagree on the owner of %s", key)
+		}
+	}
+}
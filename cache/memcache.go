@@ -0,0 +1,420 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	memcacheVersion         = "gocache-1.0"
+	defaultKeySeparator     = ":"
+	defaultMemcacheMaxValue = 1 << 20 //与 memcached 默认的 item 大小上限一致
+	maxMemcacheKeyLen       = 250
+	memcacheRelativeExpire  = 60 * 60 * 24 * 30 //exptime 超过 30 天时按 unix 时间戳处理
+	maxLineBytes            = 64 << 10          //memcache 和 redis 命令行的最大长度，不包括数据块
+)
+
+// errLineTooLong 表示客户端发送的命令行超过 maxLineBytes
+var errLineTooLong = errors.New("line too long")
+
+// MemcacheServer 在已注册的 Group 之上实现 memcached 文本协议，支持 get/gets/set/add/delete/touch/stats/version/quit。
+// key 形如 "<group><Separator><key>" 且 group 已注册时访问该 Group，否则访问 DefaultGroup。
+// 写入和删除通过 Group 转发到 key 的 owner，flags 不会被保存，读取时总是返回 0；
+// add 先读取 key（可能回源），key 不存在时才写入，不是原子操作
+type MemcacheServer struct {
+	opts    MemcacheOptions
+	started time.Time
+	stats   memcacheStats
+}
+
+// MemcacheOptions 是 MemcacheServer 的可选配置，零值字段使用默认值
+type MemcacheOptions struct {
+	// Registry 查找 Group 的 Registry，默认 DefaultRegistry
+	Registry *Registry
+	// DefaultGroup key 没有匹配的 group 前缀时使用的 Group，为空时这样的 key 读取为未命中、写入返回错误
+	DefaultGroup string
+	// Separator 分隔 group 前缀和 key，默认 ":"
+	Separator string
+	// MaxValueBytes set 允许的最大值，默认 1MB
+	MaxValueBytes int
+}
+
+type memcacheStats struct {
+	currConns  AtomicInt
+	totalConns AtomicInt
+	cmdGet     AtomicInt
+	cmdSet     AtomicInt
+	cmdTouch   AtomicInt
+	getHits    AtomicInt
+	getMisses  AtomicInt
+}
+
+// NewMemcacheServer 创建 MemcacheServer，o 为 nil 时使用默认配置
+func NewMemcacheServer(o *MemcacheOptions) *MemcacheServer {
+	s := &MemcacheServer{started: time.Now()}
+	if o != nil {
+		s.opts = *o
+	}
+	if s.opts.Registry == nil {
+		s.opts.Registry = DefaultRegistry
+	}
+	if s.opts.Separator == "" {
+		s.opts.Separator = defaultKeySeparator
+	}
+	if s.opts.MaxValueBytes <= 0 {
+		s.opts.MaxValueBytes = defaultMemcacheMaxValue
+	}
+	return s
+}
+
+func (s *MemcacheServer) Log(format string, v ...interface{}) {
+	log.Printf("[memcache] %s", fmt.Sprintf(format, v...))
+}
+
+// Accept 在 lis 上接受客户端连接，直到 lis 被关闭
+func (s *MemcacheServer) Accept(lis net.Listener) error {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// errMemcacheClient 表示客户端的请求格式错误，回复 CLIENT_ERROR 后连接继续可用
+type errMemcacheClient string
+
+func (e errMemcacheClient) Error() string { return string(e) }
+
+// ServeConn 按顺序处理一条连接上的命令，读缓冲中没有后续命令时才 flush，支持客户端流水线发送
+func (s *MemcacheServer) ServeConn(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	s.stats.currConns.Add(1)
+	s.stats.totalConns.Add(1)
+	defer s.stats.currConns.Add(-1)
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := readLine(r, maxLineBytes)
+		if err == errLineTooLong { //无法找到下一条命令的开始，回复后关闭连接
+			fmt.Fprintf(w, "CLIENT_ERROR %v\r\n", err)
+			w.Flush()
+			return
+		}
+		if err != nil {
+			if err != io.EOF {
+				s.Log("read command error: %v", err)
+			}
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			fmt.Fprint(w, "ERROR\r\n")
+		} else if quit, err := s.handle(r, w, fields); quit {
+			w.Flush()
+			return
+		} else if err != nil {
+			var ce errMemcacheClient
+			if !errors.As(err, &ce) {
+				s.Log("%s error: %v", fields[0], err)
+				w.Flush()
+				return
+			}
+			fmt.Fprintf(w, "CLIENT_ERROR %s\r\n", ce)
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readLine 读取以 \n 结尾的一行并去掉行尾的 \r\n，超过 max 字节仍没有换行时返回 errLineTooLong，
+// 避免不发送换行的客户端耗尽内存
+func readLine(r *bufio.Reader, max int) (string, error) {
+	var line []byte
+	for {
+		frag, err := r.ReadSlice('\n')
+		line = append(line, frag...)
+		if err == bufio.ErrBufferFull {
+			if len(line) >= max { //已经读满 max 字节仍然没有换行
+				return "", errLineTooLong
+			}
+			continue
+		}
+		if len(line) > max {
+			return "", errLineTooLong
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// handle 执行一条命令，返回的非 errMemcacheClient 错误表示连接已不可用
+func (s *MemcacheServer) handle(r *bufio.Reader, w *bufio.Writer, fields []string) (quit bool, err error) {
+	switch cmd, args := fields[0], fields[1:]; cmd {
+	case "get", "gets":
+		if len(args) == 0 {
+			fmt.Fprint(w, "ERROR\r\n")
+			return false, nil
+		}
+		s.get(w, args, cmd == "gets")
+	case "set", "add":
+		return false, s.store(r, w, cmd, args)
+	case "delete":
+		if len(args) < 1 || len(args) > 2 {
+			return false, errMemcacheClient("bad command line format")
+		}
+		s.delete(w, args[0], noreply(args, 1))
+	case "touch":
+		if len(args) < 2 || len(args) > 3 {
+			return false, errMemcacheClient("bad command line format")
+		}
+		exptime, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return false, errMemcacheClient("bad command line format")
+		}
+		s.touch(w, args[0], exptime, noreply(args, 2))
+	case "stats":
+		s.writeStats(w)
+	case "version":
+		fmt.Fprintf(w, "VERSION %s\r\n", memcacheVersion)
+	case "quit":
+		return true, nil
+	default:
+		fmt.Fprint(w, "ERROR\r\n")
+	}
+	return false, nil
+}
+
+// resolve 把 memcached 的 key 映射为 Group 和 Group 中的 key
+func (s *MemcacheServer) resolve(key string) (*Group, string, error) {
+	if len(key) > maxMemcacheKeyLen || strings.ContainsAny(key, " \t\r\n\x00") {
+		return nil, "", errMemcacheClient("bad key")
+	}
+	if g, k, ok := s.opts.Registry.resolveKey(key, s.opts.Separator, s.opts.DefaultGroup); ok {
+		return g, k, nil
+	}
+	return nil, "", fmt.Errorf("no group for key %s", key)
+}
+
+func (s *MemcacheServer) get(w *bufio.Writer, keys []string, cas bool) {
+	for _, key := range keys {
+		s.stats.cmdGet.Add(1)
+		g, k, err := s.resolve(key)
+		if err != nil {
+			s.stats.getMisses.Add(1)
+			continue
+		}
+		view, err := g.Get(k)
+		if errors.Is(err, ErrNotFound) {
+			s.stats.getMisses.Add(1)
+			continue
+		}
+		if err != nil { //回源失败不能当作未命中，否则客户端会以为 key 不存在
+			s.Log("get %s error: %v", key, err)
+			w.WriteString(serverError(err) + "\r\n")
+			return
+		}
+		s.stats.getHits.Add(1)
+		if cas {
+			fmt.Fprintf(w, "VALUE %s 0 %d %d\r\n", key, view.Len(), casOf(view))
+		} else {
+			fmt.Fprintf(w, "VALUE %s 0 %d\r\n", key, view.Len())
+		}
+		w.Write(view.b)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("END\r\n")
+}
+
+// store 处理 set 和 add：<cmd> <key> <flags> <exptime> <bytes> [noreply]\r\n<data>\r\n
+func (s *MemcacheServer) store(r *bufio.Reader, w *bufio.Writer, cmd string, args []string) error {
+	if len(args) < 4 || len(args) > 5 {
+		return errMemcacheClient("bad command line format")
+	}
+	_, flagsErr := strconv.ParseUint(args[1], 10, 32)
+	exptime, expErr := strconv.ParseInt(args[2], 10, 64)
+	n, lenErr := strconv.Atoi(args[3])
+	if flagsErr != nil || expErr != nil || lenErr != nil || n < 0 {
+		return errMemcacheClient("bad command line format")
+	}
+	if n > s.opts.MaxValueBytes {
+		//跳过数据块后回复错误，连接仍然可用
+		if _, err := r.Discard(n + 2); err != nil {
+			return err
+		}
+		return errMemcacheClient("object too large for cache")
+	}
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	if data[n] != '\r' || data[n+1] != '\n' {
+		return errMemcacheClient("bad data chunk")
+	}
+	s.stats.cmdSet.Add(1)
+	quiet := noreply(args, 4)
+
+	g, key, err := s.resolve(args[0])
+	if err != nil {
+		var ce errMemcacheClient
+		if errors.As(err, &ce) {
+			return err
+		}
+		return s.reply(w, quiet, serverError(err))
+	}
+	if cmd == "add" {
+		_, err := g.Get(key)
+		if err == nil {
+			return s.reply(w, quiet, "NOT_STORED")
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return s.reply(w, quiet, serverError(err))
+		}
+	}
+	value := ByteView{b: data[:n]}
+	if exptime < 0 {
+		return s.reply(w, quiet, s.deleteReply(g, key, "STORED")) //已过期的值等同于删除
+	}
+	value.e = memcacheExpire(exptime)
+	if err := g.set(key, value); err != nil {
+		return s.reply(w, quiet, serverError(err))
+	}
+	return s.reply(w, quiet, "STORED")
+}
+
+func (s *MemcacheServer) delete(w *bufio.Writer, key string, quiet bool) {
+	g, k, err := s.resolve(key)
+	if err != nil {
+		s.reply(w, quiet, "NOT_FOUND")
+		return
+	}
+	if _, err := g.getCached(context.Background(), k); errors.Is(err, ErrNotFound) {
+		s.reply(w, quiet, "NOT_FOUND")
+		return
+	}
+	s.reply(w, quiet, s.deleteReply(g, k, "DELETED")) //无法确认 key 是否存在时仍然删除
+}
+
+func (s *MemcacheServer) deleteReply(g *Group, key, ok string) string {
+	if err := g.remove(context.Background(), key); err != nil {
+		return serverError(err)
+	}
+	return ok
+}
+
+// touch 用新的过期时间重新写入已缓存的 key，不会回源
+func (s *MemcacheServer) touch(w *bufio.Writer, key string, exptime int64, quiet bool) {
+	s.stats.cmdTouch.Add(1)
+	g, k, err := s.resolve(key)
+	if err != nil {
+		s.reply(w, quiet, "NOT_FOUND")
+		return
+	}
+	view, err := g.getCached(context.Background(), k)
+	if errors.Is(err, ErrNotFound) {
+		s.reply(w, quiet, "NOT_FOUND")
+		return
+	}
+	if err != nil {
+		s.reply(w, quiet, serverError(err))
+		return
+	}
+	view.e = memcacheExpire(exptime)
+	if err := g.set(k, view); err != nil {
+		s.reply(w, quiet, serverError(err))
+		return
+	}
+	s.reply(w, quiet, "TOUCHED")
+}
+
+func (s *MemcacheServer) writeStats(w *bufio.Writer) {
+	now := time.Now()
+	stat := func(name string, v interface{}) {
+		fmt.Fprintf(w, "STAT %s %v\r\n", name, v)
+	}
+	stat("pid", os.Getpid())
+	stat("uptime", int64(now.Sub(s.started)/time.Second))
+	stat("time", now.Unix())
+	stat("version", memcacheVersion)
+	stat("curr_connections", s.stats.currConns.Get())
+	stat("total_connections", s.stats.totalConns.Get())
+	stat("cmd_get", s.stats.cmdGet.Get())
+	stat("cmd_set", s.stats.cmdSet.Get())
+	stat("cmd_touch", s.stats.cmdTouch.Get())
+	stat("get_hits", s.stats.getHits.Get())
+	stat("get_misses", s.stats.getMisses.Get())
+	var items int
+	var bytes int64
+	for _, name := range s.opts.Registry.ListGroups() {
+		g := s.opts.Registry.GetGroup(name)
+		if g == nil {
+			continue
+		}
+		info := g.info()
+		items += info.MainItems + info.HotItems
+		bytes += info.MainBytes + info.HotBytes
+		names := make([]string, 0, len(info.Stats))
+		for k := range info.Stats {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			stat("group:"+name+":"+k, info.Stats[k])
+		}
+	}
+	stat("curr_items", items)
+	stat("bytes", bytes)
+	w.WriteString("END\r\n")
+}
+
+func (s *MemcacheServer) reply(w *bufio.Writer, quiet bool, msg string) error {
+	if !quiet {
+		w.WriteString(msg + "\r\n")
+	}
+	return nil
+}
+
+func noreply(args []string, i int) bool {
+	return len(args) > i && args[i] == "noreply"
+}
+
+func serverError(err error) string {
+	return "SERVER_ERROR " + strings.ReplaceAll(err.Error(), "\n", " ")
+}
+
+// memcacheExpire 把 memcached 的 exptime 转换为过期时间：0 表示永不过期，不超过 30 天为相对秒数，否则为 unix 时间戳
+func memcacheExpire(exptime int64) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime <= memcacheRelativeExpire:
+		return time.Now().Add(time.Duration(exptime) * time.Second)
+	default:
+		return time.Unix(exptime, 0)
+	}
+}
+
+// casOf 为 gets 生成 cas 值，值不变时 cas 不变
+func casOf(v ByteView) uint64 {
+	h := fnv.New64a()
+	h.Write(v.b)
+	return h.Sum64()
+}
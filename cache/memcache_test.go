@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memcacheConn 发送命令并按行读取回复
+type memcacheConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *memcacheConn) send(cmd string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(cmd)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *memcacheConn) expect(lines ...string) {
+	c.t.Helper()
+	for _, want := range lines {
+		got, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("reading reply, expect %q: %v", want, err)
+		}
+		if got = strings.TrimSuffix(got, "\r\n"); got != want && !(strings.HasSuffix(want, "*") && strings.HasPrefix(got, strings.TrimSuffix(want, "*"))) {
+			c.t.Fatalf("reply = %q, expect %q", got, want)
+		}
+	}
+}
+
+func startMemcache(t *testing.T) (*memcacheConn, *Registry) {
+	r := NewRegistry()
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		if strings.HasPrefix(key, "missing") {
+			return nil, ErrNotFound
+		}
+		if strings.HasPrefix(key, "broken") {
+			return nil, errors.New("origin down")
+		}
+		return []byte("v-" + key), nil
+	})
+	r.NewGroupOpts("scores", getter)
+	r.NewGroupOpts("users", getter)
+	s := NewMemcacheServer(&MemcacheOptions{Registry: r, DefaultGroup: "scores", MaxValueBytes: 16})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Accept(lis)
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() {
+		conn.Close()
+		lis.Close()
+	})
+	return &memcacheConn{t: t, conn: conn, r: bufio.NewReader(conn)}, r
+}
+
+func TestMemcacheServer(t *testing.T) {
+	c, _ := startMemcache(t)
+
+	c.send("get Tom users:Jack missing nogroup:x\r\n")
+	c.expect("VALUE Tom 0 5", "v-Tom", "VALUE users:Jack 0 6", "v-Jack", "VALUE nogroup:x 0 11", "v-nogroup:x", "END")
+
+	c.send("set users:Sam 0 0 3\r\n567\r\nget users:Sam\r\n")
+	c.expect("STORED", "VALUE users:Sam 0 3", "567", "END")
+	c.send("gets users:Sam\r\n")
+	c.expect("VALUE users:Sam 0 3 *", "567", "END")
+
+	// 流水线发送多条命令，noreply 不产生回复
+	c.send("add users:Sam 0 0 1 noreply\r\nx\r\nadd missing1 0 0 1\r\ny\r\nget users:Sam missing1\r\n")
+	c.expect("STORED", "VALUE users:Sam 0 3", "567", "VALUE missing1 0 1", "y", "END")
+	c.send("add users:Sam 0 0 1\r\nz\r\n")
+	c.expect("NOT_STORED")
+
+	c.send("delete users:Sam\r\nget users:Sam\r\n")
+	c.expect("DELETED", "VALUE users:Sam 0 5", "v-Sam", "END")
+
+	c.send("touch missing2 10\r\ntouch users:Sam 10\r\n")
+	c.expect("NOT_FOUND", "TOUCHED")
+	c.send("set k 0 -1 1\r\nx\r\nget k\r\n")
+	c.expect("STORED", "VALUE k 0 3", "v-k", "END")
+
+	c.send("set big 0 0 17\r\n" + strings.Repeat("x", 17) + "\r\n")
+	c.expect("CLIENT_ERROR object too large for cache")
+	c.send("set k 0 0 x\r\nbogus\r\nversion\r\n")
+	c.expect("CLIENT_ERROR bad command line format", "ERROR", "VERSION "+memcacheVersion)
+
+	c.send("stats\r\n")
+	stats := make(map[string]string)
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "END\r\n" {
+			break
+		}
+		var name, value string
+		fmt.Sscanf(line, "STAT %s %s", &name, &value)
+		stats[name] = value
+	}
+	if stats["curr_connections"] != "1" || stats["get_hits"] == "" || stats["group:users:Gets"] == "" {
+		t.Fatalf("stats = %v", stats)
+	}
+
+	c.send("quit\r\n")
+	if _, err := c.r.ReadString('\n'); err == nil {
+		t.Fatal("expect connection closed after quit")
+	}
+}
+
+func TestMemcacheLookupOnly(t *testing.T) {
+	c, r := startMemcache(t)
+	users := r.GetGroup("users")
+
+	// touch 和 delete 只作用于已缓存的 key，不会回源
+	c.send("touch users:Absent 10\r\ndelete users:Absent\r\n")
+	c.expect("NOT_FOUND", "NOT_FOUND")
+	if n := users.Stats().LocalLoads.Get(); n != 0 {
+		t.Fatalf("LocalLoads = %d, touch and delete should not load", n)
+	}
+	c.send("get users:Jack\r\ndelete users:Jack\r\ndelete users:Jack\r\n")
+	c.expect("VALUE users:Jack 0 6", "v-Jack", "END", "DELETED", "NOT_FOUND")
+
+	// 回源失败返回 SERVER_ERROR，而不是当作未命中
+	c.send("get users:missing users:broken\r\n")
+	c.expect("SERVER_ERROR *")
+	c.send("version\r\n")
+	c.expect("VERSION " + memcacheVersion)
+}
+
+func TestMemcacheLineTooLong(t *testing.T) {
+	c, _ := startMemcache(t)
+	c.send("get " + strings.Repeat("k", maxLineBytes))
+	c.expect("CLIENT_ERROR line too long")
+	if _, err := c.r.ReadString('\n'); err == nil {
+		t.Fatal("expect connection closed after an over-long line")
+	}
+}
@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	ErrPeersRegistered       = errors.New("gocache: RegisterPeers called more than once")
+	ErrKeyProviderRegistered = errors.New("gocache: RegisterKeyProvider called more than once")
+)
+
+// Logger 是 Group 使用的日志接口，*log.Logger 实现了该接口
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// GroupOption 配置 NewGroupOpts 创建的 Group
+type GroupOption func(o *groupOptions)
+
+type groupOptions struct {
+	cacheBytes    int64
+	ttl           time.Duration
+	policy        EvictionPolicy
+	hotCacheRatio float64
+	admission     func(key string, value ByteView) bool
+	compressor    Compressor
+	compressMin   int
+	logger        Logger
+	stats         *Stats
+	peers         PeerPicker
+	keyProvider   KeyProvider
+	snapshotFile  string
+	maxInFlight   int
+	maxQueue      int
+	originRate    float64
+	originBurst   int
+	maxValueBytes int64
+
+	hedgePercentile float64
+	hedgeBudget     float64
+}
+
+// WithCacheBytes 设置 mainCache 的最大字节数，0 表示不限制
+func WithCacheBytes(n int64) GroupOption {
+	return func(o *groupOptions) { o.cacheBytes = n }
+}
+
+// WithTTL 设置从数据源加载的值的存活时间，0 表示永不过期
+func WithTTL(d time.Duration) GroupOption {
+	return func(o *groupOptions) { o.ttl = d }
+}
+
+// WithEvictionPolicy 替换默认的 LRU 淘汰策略
+func WithEvictionPolicy(p EvictionPolicy) GroupOption {
+	return func(o *groupOptions) { o.policy = p }
+}
+
+// WithHotCacheRatio 开启 hotCache，缓存一部分从其他节点取回的值，大小为 cacheBytes*ratio
+func WithHotCacheRatio(ratio float64) GroupOption {
+	return func(o *groupOptions) { o.hotCacheRatio = ratio }
+}
+
+// WithAdmission 设置准入策略，返回 false 的值不会写入缓存
+func WithAdmission(admit func(key string, value ByteView) bool) GroupOption {
+	return func(o *groupOptions) { o.admission = admit }
+}
+
+// WithCompression 对不小于 minSize 字节的值压缩后再缓存
+func WithCompression(c Compressor, minSize int) GroupOption {
+	return func(o *groupOptions) {
+		o.compressor = c
+		o.compressMin = minSize
+	}
+}
+
+func WithLogger(l Logger) GroupOption {
+	return func(o *groupOptions) { o.logger = l }
+}
+
+// WithStats 让 Group 把统计数据记录到 s 中，多个 Group 可以共享同一个 Stats
+func WithStats(s *Stats) GroupOption {
+	return func(o *groupOptions) { o.stats = s }
+}
+
+func WithPeerPicker(p PeerPicker) GroupOption {
+	return func(o *groupOptions) { o.peers = p }
+}
+
+// WithKeyProvider 开启缓存值加密，见 RegisterKeyProvider
+func WithKeyProvider(kp KeyProvider) GroupOption {
+	return func(o *groupOptions) { o.keyProvider = kp }
+}
+
+// WithSnapshotFile 在创建 Group 时加载快照文件，文件不存在时忽略
+func WithSnapshotFile(path string) GroupOption {
+	return func(o *groupOptions) { o.snapshotFile = path }
+}
+
+// WithOriginLimit 限制同时调用 Getter 的数量为 maxInFlight，超出的请求最多排队 maxQueue 个，
+// 队列已满时返回 ErrOriginOverloaded
+func WithOriginLimit(maxInFlight, maxQueue int) GroupOption {
+	return func(o *groupOptions) {
+		o.maxInFlight = maxInFlight
+		o.maxQueue = maxQueue
+	}
+}
+
+// WithOriginRateLimit 以令牌桶限制每秒调用 Getter 的次数。令牌不足的请求最多排队 maxQueue 个
+// （没有用 WithOriginLimit 设置时为 burst 个），即最多等待 maxQueue/rate，超出时返回 ErrOriginOverloaded
+func WithOriginRateLimit(rate float64, burst int) GroupOption {
+	return func(o *groupOptions) {
+		o.originRate = rate
+		o.originBurst = burst
+	}
+}
+
+// WithMaxValueBytes 拒绝超过 n 字节的值：Getter 返回或写入的值过大时返回 ErrValueTooLarge，不会被缓存
+func WithMaxValueBytes(n int64) GroupOption {
+	return func(o *groupOptions) { o.maxValueBytes = n }
+}
+
+// WithHedging 开启对冲请求：远程节点超过最近延迟的 percentile 分位（如 0.95）仍未返回时，
+// 向下一个 owner 发出对冲请求（没有下一个 owner 时在本地加载），使用先返回的结果并取消另一个。
+// 对冲请求的数量不超过远程读取次数的 budget 比例（如 0.05）
+func WithHedging(percentile, budget float64) GroupOption {
+	return func(o *groupOptions) {
+		o.hedgePercentile = percentile
+		o.hedgeBudget = budget
+	}
+}
+
+func (o *groupOptions) validate() error {
+	switch {
+	case o.cacheBytes < 0:
+		return fmt.Errorf("gocache: negative cache bytes %d", o.cacheBytes)
+	case o.ttl < 0:
+		return fmt.Errorf("gocache: negative ttl %v", o.ttl)
+	case o.hotCacheRatio < 0 || o.hotCacheRatio >= 1:
+		return fmt.Errorf("gocache: hot cache ratio %v out of range [0, 1)", o.hotCacheRatio)
+	case o.compressMin < 0:
+		return fmt.Errorf("gocache: negative compression threshold %d", o.compressMin)
+	case o.maxInFlight < 0 || o.maxQueue < 0:
+		return fmt.Errorf("gocache: negative origin limit %d/%d", o.maxInFlight, o.maxQueue)
+	case o.maxValueBytes < 0:
+		return fmt.Errorf("gocache: negative max value bytes %d", o.maxValueBytes)
+	case o.originRate < 0 || o.originBurst < 0:
+		return fmt.Errorf("gocache: negative origin rate limit %v/%d", o.originRate, o.originBurst)
+	case o.hedgePercentile < 0 || o.hedgePercentile >= 1:
+		return fmt.Errorf("gocache: hedge percentile %v out of range [0, 1)", o.hedgePercentile)
+	case o.hedgePercentile > 0 && (o.hedgeBudget <= 0 || o.hedgeBudget > 1):
+		return fmt.Errorf("gocache: hedge budget %v out of range (0, 1]", o.hedgeBudget)
+	case o.logger == nil:
+		return errors.New("gocache: nil Logger")
+	case o.stats == nil:
+		return errors.New("gocache: nil Stats")
+	case o.policy == nil:
+		return errors.New("gocache: nil EvictionPolicy")
+	}
+	return nil
+}
+
+// NewGroupOpts 在 DefaultRegistry 中创建 Group，参数不合法时返回 error 而不是 panic
+func NewGroupOpts(name string, getter Getter, opts ...GroupOption) (*Group, error) {
+	return DefaultRegistry.NewGroupOpts(name, getter, opts...)
+}
+
+// NewGroupOpts 创建并注册 Group，同名 Group 已存在时返回 ErrGroupExists
+func (r *Registry) NewGroupOpts(name string, getter Getter, opts ...GroupOption) (*Group, error) {
+	if getter == nil {
+		return nil, ErrNilGetter
+	}
+	o := groupOptions{
+		policy: LRUPolicy,
+		logger: stdLogger{},
+		stats:  &Stats{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	if r.GetGroup(name) != nil { //先检查名字，避免重复创建时白白读取整个快照
+		return nil, fmt.Errorf("%w: %s", ErrGroupExists, name)
+	}
+	g := newGroup(name, getter, &o)
+	if o.snapshotFile != "" {
+		if err := g.LoadSnapshotFile(o.snapshotFile); err != nil {
+			return nil, fmt.Errorf("gocache: load snapshot %s: %w", o.snapshotFile, err)
+		}
+	}
+	if err := r.register(g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
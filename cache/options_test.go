@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewGroupOptsValidation(t *testing.T) {
+	r := NewRegistry()
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	cases := map[string][]GroupOption{
+		"negative bytes": {WithCacheBytes(-1)},
+		"negative ttl":   {WithTTL(-time.Second)},
+		"hot ratio":      {WithHotCacheRatio(1)},
+		"nil logger":     {WithLogger(nil)},
+		"nil stats":      {WithStats(nil)},
+		"nil policy":     {WithEvictionPolicy(nil)},
+	}
+	for name, opts := range cases {
+		if _, err := r.NewGroupOpts(name, getter, opts...); err == nil {
+			t.Fatalf("%s: expect validation error", name)
+		}
+	}
+	if _, err := r.NewGroupOpts("nil getter", nil); err != ErrNilGetter {
+		t.Fatalf("expect ErrNilGetter, got %v", err)
+	}
+	if len(r.ListGroups()) != 0 {
+		t.Fatalf("invalid groups should not be registered, got %v", r.ListGroups())
+	}
+}
+
+func TestNewGroupOpts(t *testing.T) {
+	ring, _ := NewKeyRing(1, bytes.Repeat([]byte{7}, 16))
+	stats := &Stats{}
+	big := strings.Repeat("gocache", 100)
+	loads := 0
+	g, err := NewRegistry().NewGroupOpts("opts", GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		if key == "big" {
+			return []byte(big), nil
+		}
+		return []byte(key), nil
+	}),
+		WithCacheBytes(2<<10),
+		WithTTL(200*time.Millisecond),
+		WithCompression(GzipCompressor{}, 64),
+		WithKeyProvider(ring),
+		WithStats(stats),
+		WithAdmission(func(key string, value ByteView) bool {
+			return key != "skip"
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if v, err := g.Get("big"); err != nil || v.String() != big {
+			t.Fatalf("Get big = %d bytes, %v", v.Len(), err)
+		}
+	}
+	raw, _ := g.mainCache.get("big")
+	if raw.Len() >= len(big) {
+		t.Fatalf("big value should be stored compressed, got %d bytes", raw.Len())
+	}
+	v, _ := g.Get("big")
+	if v.Expire().IsZero() {
+		t.Fatal("value should carry an expire time")
+	}
+
+	g.Get("skip")
+	g.Get("skip")
+	if loads != 3 {
+		t.Fatalf("loads = %d, expect 3 (skip is not admitted)", loads)
+	}
+
+	time.Sleep(time.Until(v.Expire()) + time.Millisecond) //等到 big 过期
+	g.Get("big")
+	if loads != 4 {
+		t.Fatalf("loads = %d, expired value should be reloaded", loads)
+	}
+	if stats.Gets.Get() != 6 || stats.CacheHits.Get() != 2 || stats.LocalLoads.Get() != 4 {
+		t.Fatalf("unexpected stats: gets=%v hits=%v localLoads=%v", &stats.Gets, &stats.CacheHits, &stats.LocalLoads)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	ring, _ := NewKeyRing(1, bytes.Repeat([]byte{7}, 16))
+	g, _ := NewRegistry().NewGroupOpts("register", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithKeyProvider(ring), WithPeerPicker(NewHTTPPool("self")))
+	if err := g.RegisterPeers(NewHTTPPool("self")); err != ErrPeersRegistered {
+		t.Fatalf("RegisterPeers = %v, expect ErrPeersRegistered", err)
+	}
+	if err := g.RegisterKeyProvider(ring); err != ErrKeyProviderRegistered {
+		t.Fatalf("RegisterKeyProvider = %v, expect ErrKeyProviderRegistered", err)
+	}
+}
+
+func TestNewGroupOptsDuplicateSkipsSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dup.snap")
+	ioutil.WriteFile(path, []byte("not a snapshot"), 0644)
+
+	getter := GetterFunc(func(key string) ([]byte, error) { return []byte(key), nil })
+	r := NewRegistry()
+	if _, err := r.NewGroupOpts("dup", getter); err != nil {
+		t.Fatal(err)
+	}
+	//名字重复时直接返回，不读取快照；读取的话会得到快照格式错误
+	if _, err := r.NewGroupOpts("dup", getter, WithSnapshotFile(path)); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("expect ErrGroupExists, got %v", err)
+	}
+	if _, err := r.NewGroupOpts("other", getter, WithSnapshotFile(path)); err == nil || errors.Is(err, ErrGroupExists) {
+		t.Fatalf("expect snapshot error for a new group, got %v", err)
+	}
+}
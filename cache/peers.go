@@ -1,6 +1,9 @@
 package cache
 
-import pb "go-tools/gocachepb"
+import (
+	"context"
+	pb "go-tools/gocachepb"
+)
 
 // PeerPicker 用于根据传入的 key 选择相应节点 PeerGetter
 type PeerPicker interface {
@@ -12,3 +15,25 @@ type PeerGetter interface {
 	//Get(group string, key string) ([]byte, error)
 	Get(in *pb.Request, out *pb.Response) error //还是HTTP请求 只不过换了数据结构 用pb格式
 }
+
+// ReplicaPicker 是 PeerPicker 的可选扩展，用于一个 key 存放在多个 owner 上的场景
+type ReplicaPicker interface {
+	// PickReplicas 返回 key 的 owner 列表，主节点在前，不健康的远程节点已被跳过。
+	// self 为本机节点在列表中的位置（该位置的 PeerGetter 为 nil），本机不是 owner 时为 -1
+	PickReplicas(key string) (owners []PeerGetter, self int)
+}
+
+// PeerSetter 是 PeerGetter 的可选扩展，用于把值推送到副本节点
+type PeerSetter interface {
+	Set(in *pb.SetRequest, out *pb.SetResponse) error
+}
+
+// PeerContextGetter 是 PeerGetter 的可选扩展，读取可以通过 ctx 取消，对冲请求先返回时用它取消另一个
+type PeerContextGetter interface {
+	GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error
+}
+
+// PeerDeleter 是 PeerGetter 的可选扩展，用于删除远程节点缓存中的 key
+type PeerDeleter interface {
+	Delete(ctx context.Context, in *pb.DeleteRequest) error
+}
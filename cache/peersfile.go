@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPeersFileInterval = 5 * time.Second
+	// maxPeerWeight 节点权重的上限，每个节点在哈希环上有 Replicas*weight 个虚拟节点，权重过大会耗尽内存
+	maxPeerWeight = 100
+)
+
+// PeersFileStatus 是节点列表文件最近一次加载的结果
+type PeersFileStatus struct {
+	Path       string
+	Peers      map[string]int // 当前生效的节点及权重
+	LoadedAt   time.Time      // 最近一次成功加载的时间
+	LastError  string         // 最近一次加载失败的原因，成功加载后清空
+	LastFailed time.Time
+}
+
+// PeersFile 监视节点列表文件，文件变化时重新读取并把差异应用到 HTTPPool。
+// 文件有两种格式：每行一个地址（# 开头为注释），或 JSON 数组 [{"addr": "...", "weight": 2}]。
+// 文件内容不合法时保留上一次的配置，错误记录在日志和 Status 中
+type PeersFile struct {
+	pool     *HTTPPool
+	path     string
+	interval time.Duration
+
+	mu      sync.Mutex
+	status  PeersFileStatus
+	modTime time.Time
+	content []byte
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type peerEntry struct {
+	Addr   string `json:"addr"`
+	Weight int    `json:"weight"`
+}
+
+// WatchPeersFile 加载 path 并每隔 interval 检查一次文件变化（interval 为 0 时为 5s）。
+// 首次加载失败时返回 error；Close 或 HTTPPool.Close 后停止监视。
+// 再次调用时新文件加载成功后停止之前的监视，HTTPPool 同时只跟随一个文件
+func (p *HTTPPool) WatchPeersFile(path string, interval time.Duration) (*PeersFile, error) {
+	if interval <= 0 {
+		interval = defaultPeersFileInterval
+	}
+	f := &PeersFile{
+		pool:     p,
+		path:     path,
+		interval: interval,
+		status:   PeersFileStatus{Path: path},
+		done:     make(chan struct{}),
+	}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	prev := p.peersFile
+	p.peersFile = f
+	p.mu.Unlock()
+	if prev != nil {
+		prev.Close()
+	}
+	go f.watch()
+	return f, nil
+}
+
+// PeersFile 返回 WatchPeersFile 创建的 PeersFile，没有时返回 nil
+func (p *HTTPPool) PeersFile() *PeersFile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peersFile
+}
+
+func (f *PeersFile) Close() error {
+	f.stopOnce.Do(func() {
+		close(f.done)
+	})
+	return nil
+}
+
+// Status 返回最近一次加载的结果
+func (f *PeersFile) Status() PeersFileStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.status
+	s.Peers = make(map[string]int, len(f.status.Peers))
+	for peer, w := range f.status.Peers {
+		s.Peers[peer] = w
+	}
+	return s
+}
+
+func (f *PeersFile) watch() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-f.pool.done:
+			return
+		case <-ticker.C:
+			f.reload()
+		}
+	}
+}
+
+// reload 在文件修改时间或内容变化时重新加载
+func (f *PeersFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return f.fail(err)
+	}
+	f.mu.Lock()
+	unchanged := info.ModTime().Equal(f.modTime) && !f.status.LoadedAt.IsZero()
+	f.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return f.fail(err)
+	}
+	f.mu.Lock()
+	same := bytes.Equal(content, f.content) && !f.status.LoadedAt.IsZero()
+	f.modTime = info.ModTime()
+	if same { //文件改回了当前生效的内容
+		f.status.LastError = ""
+	}
+	f.mu.Unlock()
+	if same {
+		return nil
+	}
+
+	peers, err := parsePeers(content)
+	if err != nil {
+		return f.fail(err)
+	}
+	f.pool.SetWeighted(peers)
+
+	f.mu.Lock()
+	f.content = content
+	f.status.Peers = peers
+	f.status.LoadedAt = time.Now()
+	f.status.LastError = ""
+	f.mu.Unlock()
+	f.pool.Log("loaded %d peers from %s", len(peers), f.path)
+	return nil
+}
+
+func (f *PeersFile) fail(err error) error {
+	err = fmt.Errorf("load peers file %s: %w", f.path, err)
+	f.mu.Lock()
+	f.status.LastError = err.Error()
+	f.status.LastFailed = time.Now()
+	f.mu.Unlock()
+	f.pool.Log("%v, keep the last good configuration", err)
+	return err
+}
+
+// parsePeers 解析并校验节点列表，返回节点地址到权重的映射
+func parsePeers(content []byte) (map[string]int, error) {
+	var entries []peerEntry
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, err
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			entries = append(entries, peerEntry{Addr: line, Weight: 1})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no peers")
+	}
+	peers := make(map[string]int, len(entries))
+	for i, e := range entries {
+		if e.Weight == 0 {
+			e.Weight = 1
+		}
+		if e.Weight < 0 || e.Weight > maxPeerWeight {
+			return nil, fmt.Errorf("peer %d: weight %d out of range [1, %d]", i+1, e.Weight, maxPeerWeight)
+		}
+		u, err := url.Parse(e.Addr)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("peer %d: invalid address %q", i+1, e.Addr)
+		}
+		addr := strings.TrimSuffix(e.Addr, "/")
+		if _, ok := peers[addr]; ok {
+			return nil, fmt.Errorf("peer %d: duplicate address %q", i+1, addr)
+		}
+		peers[addr] = e.Weight
+	}
+	return peers, nil
+}
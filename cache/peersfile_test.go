@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePeers(t *testing.T) {
+	peers, err := parsePeers([]byte("# cache nodes\nhttp://a:8001\n\nhttp://b:8002/\n"))
+	if err != nil || !reflect.DeepEqual(peers, map[string]int{"http://a:8001": 1, "http://b:8002": 1}) {
+		t.Fatalf("parse lines = %v, %v", peers, err)
+	}
+	peers, err = parsePeers([]byte(`[{"addr": "http://a:8001", "weight": 3}, {"addr": "http://b:8002"}]`))
+	if err != nil || !reflect.DeepEqual(peers, map[string]int{"http://a:8001": 3, "http://b:8002": 1}) {
+		t.Fatalf("parse json = %v, %v", peers, err)
+	}
+	for _, bad := range []string{
+		"",
+		"localhost:8001",
+		"http://a:8001\nhttp://a:8001",
+		`[{"addr": "http://a:8001", "weight": -1}]`,
+		`[{"addr": "http://a:8001", "weight": 100000000}]`,
+		`[{"addr": "http://a:8001"`,
+	} {
+		if _, err := parsePeers([]byte(bad)); err == nil {
+			t.Fatalf("expect error for %q", bad)
+		}
+	}
+}
+
+func TestWatchPeersFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers")
+	write := func(content string, mtime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	pool := NewHTTPPool("http://a:8001")
+	defer pool.Close()
+	if _, err := pool.WatchPeersFile(path, time.Millisecond); err == nil {
+		t.Fatal("missing file should fail the first load")
+	}
+
+	now := time.Now()
+	write("http://a:8001\nhttp://b:8002\n", now)
+	f, err := pool.WatchPeersFile(path, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	getter := pool.httpGetters["http://b:8002"]
+
+	waitStatus := func(cond func(s PeersFileStatus) bool) PeersFileStatus {
+		deadline := time.Now().Add(time.Second)
+		for {
+			s := f.Status()
+			if cond(s) {
+				return s
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected status %+v", s)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// 非法内容保留上一次的配置
+	write("http://a:8001\nnot a url\n", now.Add(time.Second))
+	s := waitStatus(func(s PeersFileStatus) bool { return s.LastError != "" })
+	if len(s.Peers) != 2 || pool.PeerStatuses()["http://b:8002"] != (PeerStatus{Healthy: true}) {
+		t.Fatalf("last good configuration should be kept, got %+v", s)
+	}
+
+	write(`[{"addr": "http://a:8001"}, {"addr": "http://b:8002"}, {"addr": "http://c:8003", "weight": 2}]`, now.Add(2*time.Second))
+	s = waitStatus(func(s PeersFileStatus) bool { return s.LastError == "" && len(s.Peers) == 3 })
+	if pool.httpGetters["http://b:8002"] != getter {
+		t.Fatal("unchanged peers should keep their httpGetter")
+	}
+	if n := len(pool.peers.GetN("key", 5)); n != 3 {
+		t.Fatalf("ring should have 3 nodes, got %d", n)
+	}
+}
+
+func TestWatchPeersFileTwice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	ioutil.WriteFile(first, []byte("http://a:8001\nhttp://b:8002\n"), 0644)
+	ioutil.WriteFile(second, []byte("http://a:8001\nhttp://c:8003\n"), 0644)
+
+	pool := NewHTTPPool("http://a:8001")
+	defer pool.Close()
+	f1, err := pool.WatchPeersFile(first, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2, err := pool.WatchPeersFile(second, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+	select {
+	case <-f1.done:
+	default:
+		t.Fatal("the previous watcher should be stopped")
+	}
+	if pool.PeersFile() != f2 {
+		t.Fatal("PeersFile should return the latest watcher")
+	}
+	if _, ok := pool.PeerStatuses()["http://c:8003"]; !ok {
+		t.Fatal("peers should follow the second file")
+	}
+}
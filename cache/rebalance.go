@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-tools/consistenthash"
+	"time"
+)
+
+const (
+	// cacheOnlyHeader 表示只读取对方缓存中的值，未命中时返回 404 而不是回源
+	cacheOnlyHeader      = "X-Gocache-Cache-Only"
+	defaultRebalanceRate = 1000
+)
+
+// PreviousOwnerPicker 是 PeerPicker 的可选扩展。哈希环变化后的过渡期内返回 key 之前的 owner，
+// 本机新接手的 key 未命中时先读取之前的 owner 缓存中的值，再回源
+type PreviousOwnerPicker interface {
+	PickPrevious(key string) (peer PeerGetter, ok bool)
+}
+
+type cacheOnlyKey struct{}
+
+// withCacheOnly 标记向其他节点的读取只查对方的缓存
+func withCacheOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheOnlyKey{}, true)
+}
+
+func isCacheOnly(ctx context.Context) bool {
+	v, _ := ctx.Value(cacheOnlyKey{}).(bool)
+	return v
+}
+
+// peek 只在本机缓存中查找 key，未命中时返回 ErrNotFound
+func (g *Group) peek(key string) (ByteView, error) {
+	g.stats.Gets.Add(1)
+	if v, ok := g.lookupCache(key); ok {
+		g.stats.CacheHits.Add(1)
+		return v, nil
+	}
+	return ByteView{}, fmt.Errorf("%s is not cached: %w", key, ErrNotFound)
+}
+
+// getCached 只查找缓存，不会回源：先查本机，再向远程 owner 发送 cache-only 读取。
+// 都没有缓存时返回 ErrNotFound，远程 owner 出错时返回该错误。只有 HTTPPool 的节点支持 cache-only 读取
+func (g *Group) getCached(ctx context.Context, key string) (ByteView, error) {
+	if v, ok := g.lookupCache(key); ok {
+		return v, nil
+	}
+	err := fmt.Errorf("%s is not cached: %w", key, ErrNotFound)
+	owners, self := g.owners(key)
+	for i, peer := range owners {
+		if i == self {
+			continue
+		}
+		v, perr := g.getFromPeer(withCacheOnly(ctx), peer, key)
+		if perr == nil {
+			return v, nil
+		}
+		if !errors.Is(perr, ErrNotFound) {
+			err = perr
+		}
+	}
+	return ByteView{}, err
+}
+
+// fromPrevious 在过渡期内读取 key 之前的 owner 缓存中的值，读到后写入 mainCache
+func (g *Group) fromPrevious(ctx context.Context, key string) (ByteView, bool) {
+	pp, ok := g.peers.(PreviousOwnerPicker)
+	if !ok {
+		return ByteView{}, false
+	}
+	peer, ok := pp.PickPrevious(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	value, err := g.getFromPeer(withCacheOnly(ctx), peer, key)
+	if err != nil {
+		return ByteView{}, false
+	}
+	g.stats.MovedHits.Add(1)
+	g.populateCache(key, value)
+	return value, true
+}
+
+// newRing 按 weights 创建哈希环，与 SetWeighted 得到的哈希环一致
+func newRing(replicas int, fn consistenthash.Hash, weights map[string]int) *consistenthash.Map {
+	ring := consistenthash.New(replicas, fn)
+	for peer, w := range weights {
+		if w < 1 {
+			w = 1
+		}
+		ring.AddWeighted(peer, w)
+	}
+	return ring
+}
+
+// PickPrevious 返回过渡期内 key 在变化前的哈希环上健康的 owner，本机之前就是 owner 时返回 false
+func (p *HTTPPool) PickPrevious(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.prev == nil || !time.Now().Before(p.prevUntil) {
+		return nil, false
+	}
+	owners := p.prev.GetN(key, p.opts.ReplicationFactor)
+	for _, peer := range owners {
+		if peer == p.self {
+			return nil, false
+		}
+	}
+	for _, peer := range owners {
+		if getter, ok := p.httpGetters[peer]; ok && p.health.healthy(peer) {
+			return getter, true
+		}
+	}
+	return nil, false
+}
+
+// startRebalance 在哈希环变化后开始过渡期，并在后台推送归属变化的记录，取消上一次还没有完成的推送。需持有 p.mu
+func (p *HTTPPool) startRebalance(prev *consistenthash.Map) {
+	if p.stopRebalance != nil {
+		p.stopRebalance()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.prev, p.prevUntil, p.stopRebalance = prev, time.Now().Add(p.opts.RebalanceWindow), cancel
+	go func() {
+		defer cancel()
+		bucket := newTokenBucket(p.opts.RebalanceRate, 1, 1)
+		for _, name := range p.opts.Registry.ListGroups() {
+			g := p.opts.Registry.GetGroup(name)
+			if g == nil || ctx.Err() != nil {
+				continue
+			}
+			start := time.Now()
+			sent, failed := p.pushEntries(ctx, g, 0, func(key string) []*httpGetter {
+				return p.movedTo(prev, key)
+			}, bucket)
+			if sent+failed > 0 {
+				p.Log("rebalance group %s: %d entries sent, %d failed in %v", name, sent, failed, time.Since(start))
+			}
+		}
+	}()
+}
+
+// movedTo 返回本机在变化前的哈希环上是 owner 时，key 在当前哈希环上新增的 owner
+func (p *HTTPPool) movedTo(prev *consistenthash.Map, key string) []*httpGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	was := make(map[string]bool, p.opts.ReplicationFactor)
+	for _, peer := range prev.GetN(key, p.opts.ReplicationFactor) {
+		was[peer] = true
+	}
+	if !was[p.self] || p.peers == nil {
+		return nil
+	}
+	var targets []*httpGetter
+	for _, peer := range p.peers.GetN(key, p.opts.ReplicationFactor) {
+		if !was[peer] && peer != p.self && p.health.healthy(peer) {
+			targets = append(targets, p.httpGetters[peer])
+		}
+	}
+	return targets
+}
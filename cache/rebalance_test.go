@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRebalance(t *testing.T) {
+	nodes := newTestCluster(t, 3, HTTPPoolOptions{RebalanceWindow: time.Minute})
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	for _, node := range nodes {
+		node.pool.Set(a.url(), b.url())
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := a.group.Get(fmt.Sprint("key", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// c 加入后 a 和 b 把归属 c 的记录推送给 c
+	for _, node := range nodes {
+		node.pool.Set(a.url(), b.url(), c.url())
+	}
+	var moved []string
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("key", i)
+		if c.pool.peers.Get(key) == c.url() {
+			moved = append(moved, key)
+		}
+	}
+	if len(moved) < 2 {
+		t.Fatalf("only %d keys moved to the new node", len(moved))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pushed := int64(0); pushed < int64(len(moved)); {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d moved keys pushed to the new node", pushed, len(moved))
+		}
+		time.Sleep(5 * time.Millisecond)
+		pushed = a.group.Stats().MovedPushes.Get() + b.group.Stats().MovedPushes.Get()
+	}
+	for _, key := range moved[1:] {
+		if v, err := a.group.Get(key); err != nil || v.String() != "v-"+key {
+			t.Fatalf("Get(%s) = %s, %v", key, v.String(), err)
+		}
+	}
+	if n := atomic.LoadInt32(c.loads); n != 0 {
+		t.Fatalf("new node loaded %d moved keys from origin", n)
+	}
+
+	// 还没有推送到的 key 由之前的 owner 提供
+	c.group.removeLocally(moved[0])
+	if v, err := c.group.Get(moved[0]); err != nil || v.String() != "v-"+moved[0] {
+		t.Fatalf("Get(%s) = %s, %v", moved[0], v.String(), err)
+	}
+	if n := atomic.LoadInt32(c.loads); n != 0 || c.group.Stats().MovedHits.Get() != 1 {
+		t.Fatalf("loads = %d, MovedHits = %v, expect the previous owner to serve the key", n, &c.group.Stats().MovedHits)
+	}
+
+	// 之前的 owner 也没有缓存时回源，之前的 owner 不会因此回源
+	var key string
+	for i := 100; key == ""; i++ {
+		if k := fmt.Sprint("key", i); c.pool.peers.Get(k) == c.url() {
+			key = k
+		}
+	}
+	before := atomic.LoadInt32(a.loads) + atomic.LoadInt32(b.loads)
+	if _, err := c.group.Get(key); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(c.loads) != 1 || atomic.LoadInt32(a.loads)+atomic.LoadInt32(b.loads) != before {
+		t.Fatalf("loads = %d %d %d, expect only the new owner to load", *a.loads, *b.loads, *c.loads)
+	}
+}
@@ -0,0 +1,392 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	redisVersion         = "6.0.0" //部分客户端按版本号决定使用的命令，声明为兼容 RESP2 的版本
+	defaultRedisMaxValue = 64 << 20
+	maxRedisArgs         = 1 << 16
+)
+
+// redisArity 是每个命令参数个数的 [最小值, 最大值]，-1 表示不限
+var redisArity = map[string][2]int{
+	"PING": {0, 1}, "GET": {1, 1}, "MGET": {1, -1}, "SET": {2, 4}, "DEL": {1, -1},
+	"EXISTS": {1, -1}, "TTL": {1, 1}, "PTTL": {1, 1}, "INFO": {0, 1}, "QUIT": {0, 0},
+	"COMMAND": {0, -1}, "SELECT": {1, 1},
+}
+
+// RedisServer 在已注册的 Group 之上实现 RESP2 协议，支持 GET/MGET/SET（EX/PX）/DEL/EXISTS/TTL/PING/INFO。
+// key 的映射规则与 MemcacheServer 相同。读取是 read-through 的，EXISTS 和 TTL 会在未命中时回源；
+// DEL 返回请求删除的 key 数量，不检查 key 是否存在
+type RedisServer struct {
+	opts    RedisOptions
+	started time.Time
+	stats   redisStats
+}
+
+// RedisOptions 是 RedisServer 的可选配置，零值字段使用默认值
+type RedisOptions struct {
+	// Registry 查找 Group 的 Registry，默认 DefaultRegistry
+	Registry *Registry
+	// DefaultGroup key 没有匹配的 group 前缀时使用的 Group，为空时这样的 key 读取为 nil、写入返回错误
+	DefaultGroup string
+	// Separator 分隔 group 前缀和 key，默认 ":"
+	Separator string
+	// MaxValueBytes 单个参数允许的最大长度，默认 64MB
+	MaxValueBytes int
+}
+
+type redisStats struct {
+	currConns  AtomicInt
+	totalConns AtomicInt
+	commands   AtomicInt
+	hits       AtomicInt
+	misses     AtomicInt
+}
+
+// errRedisProtocol 表示请求不符合 RESP 协议，回复错误后关闭连接
+type errRedisProtocol string
+
+func (e errRedisProtocol) Error() string { return "Protocol error: " + string(e) }
+
+// NewRedisServer 创建 RedisServer，o 为 nil 时使用默认配置
+func NewRedisServer(o *RedisOptions) *RedisServer {
+	s := &RedisServer{started: time.Now()}
+	if o != nil {
+		s.opts = *o
+	}
+	if s.opts.Registry == nil {
+		s.opts.Registry = DefaultRegistry
+	}
+	if s.opts.Separator == "" {
+		s.opts.Separator = defaultKeySeparator
+	}
+	if s.opts.MaxValueBytes <= 0 {
+		s.opts.MaxValueBytes = defaultRedisMaxValue
+	}
+	return s
+}
+
+func (s *RedisServer) Log(format string, v ...interface{}) {
+	log.Printf("[redis] %s", fmt.Sprintf(format, v...))
+}
+
+// Accept 在 lis 上接受客户端连接，直到 lis 被关闭
+func (s *RedisServer) Accept(lis net.Listener) error {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn 按顺序处理一条连接上的命令，读缓冲中没有后续命令时才 flush，支持客户端流水线发送
+func (s *RedisServer) ServeConn(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	s.stats.currConns.Add(1)
+	s.stats.totalConns.Add(1)
+	defer s.stats.currConns.Add(-1)
+
+	r := bufio.NewReader(conn)
+	w := &respWriter{bufio.NewWriter(conn)}
+	for {
+		args, err := s.readCommand(r)
+		if err != nil {
+			var pe errRedisProtocol
+			if errors.As(err, &pe) {
+				w.error(pe.Error())
+				w.Flush()
+			} else if err != io.EOF {
+				s.Log("read command error: %v", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		s.stats.commands.Add(1)
+		if quit := s.handle(w, args); quit {
+			w.Flush()
+			return
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readCommand 读取一条命令：RESP 数组 *<n>\r\n$<len>\r\n<arg>\r\n...，或以空格分隔的 inline 命令
+func (s *RedisServer) readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < -1 || n > maxRedisArgs {
+		return nil, errRedisProtocol("invalid multibulk length")
+	}
+	if n <= 0 { //*-1 和 *0 是空命令，与 redis 一致直接忽略
+		return nil, nil
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errRedisProtocol(fmt.Sprintf("expected '$', got '%.1s'", line))
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > s.opts.MaxValueBytes {
+			return nil, errRedisProtocol("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, errRedisProtocol("bulk string not terminated by CRLF")
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := readLine(r, maxLineBytes)
+	if err == errLineTooLong {
+		return "", errRedisProtocol("too big inline request")
+	}
+	return line, err
+}
+
+// handle 执行一条命令并写入回复，quit 为 true 时关闭连接
+func (s *RedisServer) handle(w *respWriter, args []string) (quit bool) {
+	cmd := strings.ToUpper(args[0])
+	args = args[1:]
+	a, ok := redisArity[cmd]
+	if !ok {
+		w.error(fmt.Sprintf("ERR unknown command '%s'", truncateCommand(cmd)))
+		return false
+	}
+	if len(args) < a[0] || (a[1] >= 0 && len(args) > a[1]) {
+		w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+		return false
+	}
+
+	switch cmd {
+	case "PING":
+		if len(args) == 1 {
+			w.bulk([]byte(args[0]))
+		} else {
+			w.simple("PONG")
+		}
+	case "GET":
+		s.get(w, args[0])
+	case "MGET":
+		w.array(len(args))
+		for _, key := range args {
+			s.get(w, key)
+		}
+	case "SET":
+		s.set(w, args)
+	case "DEL": //只统计缓存中存在的 key，无法确认是否存在时按存在处理并删除
+		var n int64
+		for _, key := range args {
+			g, k, ok := s.opts.Registry.resolveKey(key, s.opts.Separator, s.opts.DefaultGroup)
+			if !ok {
+				continue
+			}
+			if _, err := g.getCached(context.Background(), k); errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err := g.remove(context.Background(), k); err != nil {
+				w.error("ERR " + err.Error())
+				return false
+			}
+			n++
+		}
+		w.integer(n)
+	case "EXISTS":
+		var n int64
+		for _, key := range args {
+			if _, err := s.lookup(key); err == nil {
+				n++
+			}
+		}
+		w.integer(n)
+	case "TTL", "PTTL":
+		s.ttl(w, args[0], cmd == "PTTL")
+	case "INFO":
+		w.bulk([]byte(s.info()))
+	case "QUIT":
+		w.simple("OK")
+		return true
+	case "COMMAND": //redis-cli 启动时会发送 COMMAND DOCS
+		w.array(0)
+	case "SELECT":
+		if args[0] != "0" {
+			w.error("ERR DB index is out of range")
+		} else {
+			w.simple("OK")
+		}
+	}
+	return false
+}
+
+// lookup 读取 key，key 没有对应的 Group 时返回 ErrNotFound
+func (s *RedisServer) lookup(key string) (ByteView, error) {
+	g, k, ok := s.opts.Registry.resolveKey(key, s.opts.Separator, s.opts.DefaultGroup)
+	if !ok {
+		return ByteView{}, ErrNotFound
+	}
+	return g.Get(k)
+}
+
+func (s *RedisServer) get(w *respWriter, key string) {
+	view, err := s.lookup(key)
+	switch {
+	case err == nil:
+		s.stats.hits.Add(1)
+		w.bulk(view.b)
+	case errors.Is(err, ErrNotFound):
+		s.stats.misses.Add(1)
+		w.null()
+	default:
+		s.stats.misses.Add(1)
+		w.error("ERR " + err.Error())
+	}
+}
+
+// set 处理 SET key value [EX seconds|PX milliseconds]
+func (s *RedisServer) set(w *respWriter, args []string) {
+	g, key, ok := s.opts.Registry.resolveKey(args[0], s.opts.Separator, s.opts.DefaultGroup)
+	if !ok {
+		w.error("ERR no group for key " + args[0])
+		return
+	}
+	value := ByteView{b: []byte(args[1])}
+	if len(args) > 2 {
+		if len(args) != 4 {
+			w.error("ERR syntax error")
+			return
+		}
+		n, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil || n <= 0 {
+			w.error("ERR invalid expire time in 'set' command")
+			return
+		}
+		switch strings.ToUpper(args[2]) {
+		case "EX":
+			value.e = time.Now().Add(time.Duration(n) * time.Second)
+		case "PX":
+			value.e = time.Now().Add(time.Duration(n) * time.Millisecond)
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+	if err := g.set(key, value); err != nil {
+		w.error("ERR " + err.Error())
+		return
+	}
+	w.simple("OK")
+}
+
+// ttl 与 Redis 一致：key 不存在时返回 -2，没有过期时间时返回 -1
+func (s *RedisServer) ttl(w *respWriter, key string, millis bool) {
+	view, err := s.lookup(key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.integer(-2)
+	case err != nil:
+		w.error("ERR " + err.Error())
+	case view.e.IsZero():
+		w.integer(-1)
+	case millis:
+		w.integer(int64(time.Until(view.e) / time.Millisecond))
+	default:
+		w.integer(int64((time.Until(view.e) + time.Second - 1) / time.Second))
+	}
+}
+
+// info 返回 INFO 命令的内容，每个 Group 在 Keyspace 段中占一行
+func (s *RedisServer) info() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Server\r\nredis_version:%s\r\nredis_mode:standalone\r\nprocess_id:%d\r\nuptime_in_seconds:%d\r\n\r\n",
+		redisVersion, os.Getpid(), int64(time.Since(s.started)/time.Second))
+	fmt.Fprintf(&b, "# Clients\r\nconnected_clients:%d\r\n\r\n", s.stats.currConns.Get())
+	fmt.Fprintf(&b, "# Stats\r\ntotal_connections_received:%d\r\ntotal_commands_processed:%d\r\nkeyspace_hits:%d\r\nkeyspace_misses:%d\r\n\r\n",
+		s.stats.totalConns.Get(), s.stats.commands.Get(), s.stats.hits.Get(), s.stats.misses.Get())
+	b.WriteString("# Keyspace\r\n")
+	for _, name := range s.opts.Registry.ListGroups() {
+		g := s.opts.Registry.GetGroup(name)
+		if g == nil {
+			continue
+		}
+		info := g.info()
+		fmt.Fprintf(&b, "%s:keys=%d,bytes=%d,gets=%d,hits=%d,loads=%d\r\n", name,
+			info.MainItems+info.HotItems, info.MainBytes+info.HotBytes,
+			info.Stats["Gets"], info.Stats["CacheHits"], info.Stats["Loads"])
+	}
+	return b.String()
+}
+
+// truncateCommand 用于错误信息，避免把过长的参数写回客户端
+func truncateCommand(cmd string) string {
+	if len(cmd) > 64 {
+		cmd = cmd[:64]
+	}
+	return strings.ToLower(cmd)
+}
+
+// respWriter 写出 RESP2 格式的回复
+type respWriter struct {
+	*bufio.Writer
+}
+
+func (w *respWriter) simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func (w *respWriter) error(msg string) {
+	w.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(msg) + "\r\n")
+}
+
+func (w *respWriter) integer(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *respWriter) bulk(b []byte) {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w *respWriter) null() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w *respWriter) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// respCommand 把参数编码为 RESP 数组
+func respCommand(args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b.String()
+}
+
+func TestRedisServer(t *testing.T) {
+	r := NewRegistry()
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		if strings.HasPrefix(key, "missing") {
+			return nil, ErrNotFound
+		}
+		return []byte("v-" + key), nil
+	})
+	r.NewGroupOpts("scores", getter)
+	r.NewGroupOpts("users", getter, WithTTL(time.Hour))
+	s := NewRedisServer(&RedisOptions{Registry: r, DefaultGroup: "scores"})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go s.Accept(lis)
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c := &memcacheConn{t: t, conn: conn, r: bufio.NewReader(conn)} //按行读取回复的方式与 memcached 相同
+
+	c.send(respCommand("PING") + "PING hello\r\n")
+	c.expect("+PONG", "$5", "hello")
+
+	c.send(respCommand("GET", "Tom") + respCommand("get", "missing"))
+	c.expect("$5", "v-Tom", "$-1")
+	c.send(respCommand("MGET", "users:Jack", "missing", "Sam"))
+	c.expect("*3", "$6", "v-Jack", "$-1", "$5", "v-Sam")
+
+	c.send(respCommand("SET", "users:Sam", "a\r\nb") + respCommand("GET", "users:Sam"))
+	c.expect("+OK", "$4", "a", "b")
+	c.send(respCommand("SET", "k", "v", "EX", "100") + respCommand("TTL", "k") + respCommand("PTTL", "k"))
+	c.expect("+OK", ":100", ":*")
+	c.send(respCommand("SET", "k", "v", "PX", "0") + respCommand("SET", "k", "v", "XX", "1"))
+	c.expect("-ERR invalid expire time in 'set' command", "-ERR syntax error")
+
+	c.send(respCommand("TTL", "Tom") + respCommand("TTL", "missing") + respCommand("TTL", "users:Jack"))
+	c.expect(":-1", ":-2", ":3600")
+
+	c.send(respCommand("EXISTS", "Tom", "missing", "users:x") + respCommand("DEL", "users:Sam", "k"))
+	c.expect(":2", ":2")
+	c.send(respCommand("DEL", "users:Sam", "never-cached", "unknown:x"))
+	c.expect(":0")
+	c.send(respCommand("GET", "users:Sam"))
+	c.expect("$5", "v-Sam")
+
+	c.send(respCommand("GET") + respCommand("FLUSHALL"))
+	c.expect("-ERR wrong number of arguments for 'get' command", "-ERR unknown command 'flushall'")
+
+	c.send(respCommand("INFO"))
+	c.expect("$*")
+	var info strings.Builder
+	for !strings.Contains(info.String(), "users:keys=") {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("INFO = %q: %v", info.String(), err)
+		}
+		info.WriteString(line)
+	}
+	if !strings.Contains(info.String(), "connected_clients:1") {
+		t.Fatalf("INFO = %q", info.String())
+	}
+	c.r.ReadString('\n') //跳过 bulk string 结尾的 \r\n
+
+	c.send("*1\r\n+GET\r\n")
+	c.expect("-Protocol error: expected '$', got '+'")
+}
+
+func TestRedisProtocolLimits(t *testing.T) {
+	s := NewRedisServer(&RedisOptions{Registry: NewRegistry()})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go s.Accept(lis)
+	dial := func() *memcacheConn {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return &memcacheConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+	}
+
+	// *-1 和 *0 是空命令，连接继续可用
+	c := dial()
+	c.send("*-1\r\n*0\r\n" + respCommand("PING"))
+	c.expect("+PONG")
+
+	c = dial()
+	c.send("*-2\r\n")
+	c.expect("-Protocol error: invalid multibulk length")
+
+	// 不发送换行的客户端不能让服务端无限制地缓存
+	c = dial()
+	c.send(strings.Repeat("x", maxLineBytes+1))
+	c.expect("-Protocol error: too big inline request")
+	if _, err := c.r.ReadString('\n'); err == nil {
+		t.Fatal("expect connection closed after a protocol error")
+	}
+}
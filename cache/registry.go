@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrNilGetter    = errors.New("gocache: nil Getter")
+	ErrGroupExists  = errors.New("gocache: group already exists")
+	ErrGroupRemoved = errors.New("gocache: group has been removed")
+)
+
+// DefaultRegistry 是包级别 NewGroup/GetGroup 等函数使用的 Registry
+var DefaultRegistry = NewRegistry()
+
+// Registry 管理一组互相隔离的 Group，测试或多租户场景可以各自创建 Registry
+type Registry struct {
+	mu     sync.RWMutex
+	groups map[string]*Group
+}
+
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[string]*Group)}
+}
+
+// NewGroup 创建并注册 Group，同名 Group 已存在时返回 ErrGroupExists
+func (r *Registry) NewGroup(name string, cacheBytes int64, getter Getter) (*Group, error) {
+	return r.NewGroupOpts(name, getter, WithCacheBytes(cacheBytes))
+}
+
+func (r *Registry) register(g *Group) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.groups[g.name]; ok {
+		return fmt.Errorf("%w: %s", ErrGroupExists, g.name)
+	}
+	r.groups[g.name] = g
+	return nil
+}
+
+func (r *Registry) GetGroup(name string) *Group {
+	r.mu.RLock()
+	g := r.groups[name]
+	r.mu.RUnlock()
+	return g
+}
+
+// ListGroups 按名字排序返回所有 Group 的名字
+func (r *Registry) ListGroups() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.groups))
+	for name := range r.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RemoveGroup 注销 Group 并释放其 mainCache 和 hotCache，之后对该 Group 的 Get 返回 ErrGroupRemoved。
+// HTTPPool、gRPC 等 handler 每次请求都从 Registry 查找 Group，对端节点再请求该 Group 会得到 404 并回退到本地加载。
+// 返回 Group 是否存在
+func (r *Registry) RemoveGroup(name string) bool {
+	r.mu.Lock()
+	g, ok := r.groups[name]
+	if ok {
+		delete(r.groups, name)
+	}
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	atomic.StoreInt32(&g.removed, 1)
+	g.purgeLocally()
+	return true
+}
+
+// resolveKey 把 memcached、Redis 等前端的 key 映射为 Group 和 Group 中的 key：
+// key 形如 "<group><sep><key>" 且 group 已注册时使用该 Group，否则使用 defaultGroup
+func (r *Registry) resolveKey(key, sep, defaultGroup string) (*Group, string, bool) {
+	if i := strings.Index(key, sep); i > 0 {
+		if g := r.GetGroup(key[:i]); g != nil {
+			return g, key[i+len(sep):], true
+		}
+	}
+	if defaultGroup != "" {
+		if g := r.GetGroup(defaultGroup); g != nil {
+			return g, key, true
+		}
+	}
+	return nil, "", false
+}
+
+func ListGroups() []string {
+	return DefaultRegistry.ListGroups()
+}
+
+func RemoveGroup(name string) bool {
+	return DefaultRegistry.RemoveGroup(name)
+}
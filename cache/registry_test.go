@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	if _, err := r.NewGroup("nil", 2<<10, nil); err != ErrNilGetter {
+		t.Fatalf("expect ErrNilGetter, got %v", err)
+	}
+	g, err := r.NewGroup("b", 2<<10, getter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.NewGroup("a", 2<<10, getter); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.NewGroup("b", 2<<10, getter); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("expect ErrGroupExists, got %v", err)
+	}
+	if names := r.ListGroups(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatalf("ListGroups = %v", names)
+	}
+	if DefaultRegistry.GetGroup("b") != nil {
+		t.Fatal("groups of an isolated registry should not leak into DefaultRegistry")
+	}
+
+	g.Get("k")
+	g.hotCache.add("hot", ByteView{b: []byte("hot")})
+	if !r.RemoveGroup("b") || r.RemoveGroup("b") {
+		t.Fatal("RemoveGroup should report whether the group existed")
+	}
+	if _, ok := g.mainCache.get("k"); ok {
+		t.Fatal("cache of removed group should be released")
+	}
+	if _, ok := g.hotCache.get("hot"); ok {
+		t.Fatal("hot cache of removed group should be released")
+	}
+	if _, err := g.Get("k"); err != ErrGroupRemoved {
+		t.Fatalf("expect ErrGroupRemoved, got %v", err)
+	}
+}
+
+func TestHTTPPoolRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.NewGroup("isolated", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	p := NewHTTPPoolOpts("self", &HTTPPoolOptions{Registry: r})
+
+	for path, code := range map[string]int{
+		"/_gocache/isolated/k": http.StatusOK,
+		"/_gocache/scores/k":   http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != code {
+			t.Fatalf("%s got %d, expect %d", path, rec.Code, code)
+		}
+	}
+	r.RemoveGroup("isolated")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_gocache/isolated/k", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("removed group should be 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testNode struct {
+	pool  *HTTPPool
+	group *Group
+	srv   *httptest.Server
+	loads *int32
+}
+
+// newTestCluster 在本机启动 n 个节点，每个节点有独立的 Registry 和名为 group 的 Group
+func newTestCluster(t *testing.T, n int, o HTTPPoolOptions) []*testNode {
+	nodes := make([]*testNode, n)
+	addrs := make([]string, n)
+	for i := range nodes {
+		node := &testNode{loads: new(int32)}
+		node.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			node.pool.ServeHTTP(w, r)
+		}))
+		nodes[i] = node
+		addrs[i] = node.srv.URL
+	}
+	for _, node := range nodes {
+		node := node
+		r := NewRegistry()
+		opts := o
+		opts.Registry = r
+		node.pool = NewHTTPPoolOpts(node.srv.URL, &opts)
+		node.pool.Set(addrs...)
+		node.group, _ = r.NewGroupOpts("group", GetterFunc(func(key string) ([]byte, error) {
+			atomic.AddInt32(node.loads, 1)
+			return []byte("v-" + key), nil
+		}), WithPeerPicker(node.pool))
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.srv.Close()
+			node.pool.Close()
+		}
+	})
+	return nodes
+}
+
+func (n *testNode) url() string {
+	return n.srv.URL
+}
+
+func TestReplicatedRead(t *testing.T) {
+	nodes := newTestCluster(t, 3, HTTPPoolOptions{ReplicationFactor: 2, FailureThreshold: 1, MinBackoff: time.Minute})
+	byURL := make(map[string]*testNode)
+	for _, node := range nodes {
+		byURL[node.url()] = node
+	}
+
+	// 找一个 owner 为 [primary, replica]、由第三个节点读取的 key
+	var key string
+	var primary, replica, reader *testNode
+	for i := 0; key == ""; i++ {
+		owners := nodes[0].pool.peers.GetN(fmt.Sprint(i), 2)
+		primary, replica = byURL[owners[0]], byURL[owners[1]]
+		for _, node := range nodes {
+			if node != primary && node != replica {
+				reader, key = node, fmt.Sprint(i)
+			}
+		}
+	}
+
+	if v, err := reader.group.Get(key); err != nil || v.String() != "v-"+key {
+		t.Fatalf("Get = %q, %v", v.String(), err)
+	}
+	if *primary.loads != 1 || *replica.loads != 0 || *reader.loads != 0 {
+		t.Fatalf("only the primary should load from origin, loads = %d %d %d", *primary.loads, *replica.loads, *reader.loads)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := replica.group.lookupCache(key); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("value should be pushed to the replica")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 主节点下线后从副本读取，不再回源
+	primary.srv.Close()
+	if v, err := reader.group.Get(key); err != nil || v.String() != "v-"+key {
+		t.Fatalf("Get after owner loss = %q, %v", v.String(), err)
+	}
+	if *replica.loads != 0 || *reader.loads != 0 {
+		t.Fatalf("replica should serve the key from cache, loads = %d %d", *replica.loads, *reader.loads)
+	}
+	if reader.group.Stats().PeerErrors.Get() != 1 {
+		t.Fatalf("PeerErrors = %v, expect 1 failed read of the primary", &reader.group.Stats().PeerErrors)
+	}
+}
+
+func TestOwnerFailover(t *testing.T) {
+	nodes := newTestCluster(t, 3, HTTPPoolOptions{FailureThreshold: 1, MinBackoff: time.Minute})
+	a, b, c := nodes[0], nodes[1], nodes[2]
+
+	// 找一个 b 拥有的 key 和一个环上顺序为 b、c、a 的 key
+	var trip, key string
+	for i := 0; trip == "" || key == ""; i++ {
+		owners := a.pool.peers.GetN(fmt.Sprint(i), 3)
+		if owners[0] != b.url() {
+			continue
+		}
+		if trip == "" {
+			trip = fmt.Sprint(i)
+		} else if owners[1] == c.url() {
+			key = fmt.Sprint(i)
+		}
+	}
+
+	b.srv.Close()
+	if v, err := a.group.Get(trip); err != nil || v.String() != "v-"+trip {
+		t.Fatalf("Get(%s) = %q, %v", trip, v.String(), err)
+	}
+	if a.pool.PeerStatuses()[b.url()].Healthy {
+		t.Fatal("b should be marked unhealthy")
+	}
+
+	// b 不健康时由环上的下一个节点 c 接替，而不是在本地回源
+	loads := atomic.LoadInt32(a.loads)
+	if v, err := a.group.Get(key); err != nil || v.String() != "v-"+key {
+		t.Fatalf("Get(%s) = %q, %v", key, v.String(), err)
+	}
+	if n := atomic.LoadInt32(a.loads) - loads; n != 0 || atomic.LoadInt32(c.loads) != 1 {
+		t.Fatalf("key should fail over to c, local loads = %d, c loads = %d", n, atomic.LoadInt32(c.loads))
+	}
+}
+
+func TestGetCached(t *testing.T) {
+	nodes := newTestCluster(t, 2, HTTPPoolOptions{})
+	a, b := nodes[0], nodes[1]
+	key := ""
+	for i := 0; key == ""; i++ {
+		if peer, ok := a.pool.PickPeer(fmt.Sprint(i)); ok && peer.(*httpGetter).peer == b.url() {
+			key = fmt.Sprint(i)
+		}
+	}
+	if _, err := a.group.getCached(context.Background(), key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("getCached before load = %v, expect ErrNotFound", err)
+	}
+	if *a.loads != 0 || *b.loads != 0 {
+		t.Fatalf("getCached should not load, loads = %d %d", *a.loads, *b.loads)
+	}
+	b.group.Get(key)
+	if v, err := a.group.getCached(context.Background(), key); err != nil || v.String() != "v-"+key {
+		t.Fatalf("getCached = %q, %v", v.String(), err)
+	}
+}
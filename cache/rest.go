@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound 由 Getter 返回（可以被包装）表示数据源中没有该 key，REST API 会返回 404
+var ErrNotFound = errors.New("gocache: not found")
+
+const (
+	defaultRESTPath = "/v1/"
+	ttlHeader       = "X-Gocache-Ttl" //PUT 时指定的过期时间和 GET 时返回的剩余时间，单位秒
+
+	maxRESTValueBytes = 64 << 20
+	maxBatchKeys      = 1000
+)
+
+// RESTHandler 是面向缓存客户端的 JSON REST API：
+//
+//	GET    <base>groups/<group>/keys/<key>    读取 key，返回原始值；支持 ETag 和 If-None-Match
+//	PUT    <base>groups/<group>/keys/<key>    写入 key，保存请求的 Content-Type，X-Gocache-Ttl 指定过期秒数
+//	DELETE <base>groups/<group>/keys/<key>    删除 key
+//	GET    <base>groups/<group>/keys?key=a&key=b
+//	POST   <base>groups/<group>/keys          批量读取，body 为 {"keys": [...]}
+//
+// 出错时返回 {"error": "..."}
+type RESTHandler struct {
+	registry *Registry
+	basePath string
+}
+
+// NewRESTHandler 创建 RESTHandler，registry 为 nil 时使用 DefaultRegistry，basePath 为空时使用 "/v1/"
+func NewRESTHandler(registry *Registry, basePath string) *RESTHandler {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	if basePath == "" {
+		basePath = defaultRESTPath
+	}
+	if !strings.HasSuffix(basePath, "/") {
+		basePath += "/"
+	}
+	return &RESTHandler{registry: registry, basePath: basePath}
+}
+
+// BatchResponse 是批量读取的结果，读取成功的 key 在 Values 里，失败的 key 在 Errors 里
+type BatchResponse struct {
+	Values map[string][]byte `json:"values"`
+	Errors map[string]string `json:"errors"`
+}
+
+func (h *RESTHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, h.basePath) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	// groups/<group>/keys[/<key>]，key 中可以包含 "/"
+	parts := strings.SplitN(r.URL.Path[len(h.basePath):], "/", 4)
+	if len(parts) < 3 || parts[0] != "groups" || parts[2] != "keys" {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	group := h.registry.GetGroup(parts[1])
+	if group == nil {
+		writeJSONError(w, http.StatusNotFound, "no such group: "+parts[1])
+		return
+	}
+	if len(parts) == 3 {
+		h.batchGet(w, r, group)
+		return
+	}
+	key := parts[3]
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, group, key)
+	case http.MethodPut:
+		h.put(w, r, group, key)
+	case http.MethodDelete:
+		if err := group.remove(r.Context(), key); err != nil {
+			writeRESTError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *RESTHandler) get(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	view, err := group.GetContext(r.Context(), key)
+	if err != nil {
+		writeRESTError(w, err)
+		return
+	}
+	etag := etagOf(view)
+	header := w.Header()
+	header.Set("ETag", etag)
+	if !view.e.IsZero() {
+		ttl := time.Until(view.e)
+		header.Set(ttlHeader, strconv.FormatInt(int64((ttl+time.Second-1)/time.Second), 10))
+		header.Set("Expires", view.e.UTC().Format(http.TimeFormat))
+	}
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	ct := view.ct
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	header.Set("Content-Type", ct)
+	header.Set("Content-Length", strconv.Itoa(view.Len()))
+	w.Write(view.b)
+}
+
+func (h *RESTHandler) put(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRESTValueBytes))
+	if err != nil {
+		code := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		writeJSONError(w, code, err.Error())
+		return
+	}
+	value := ByteView{b: body, ct: r.Header.Get("Content-Type")}
+	if s := r.Header.Get(ttlHeader); s != "" {
+		seconds, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || seconds <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid "+ttlHeader+": "+s)
+			return
+		}
+		value.e = time.Now().Add(time.Duration(seconds) * time.Second)
+	} else if group.opts.ttl > 0 {
+		value.e = time.Now().Add(group.opts.ttl)
+	}
+	if err := group.set(key, value); err != nil {
+		writeRESTError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etagOf(value))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *RESTHandler) batchGet(w http.ResponseWriter, r *http.Request, group *Group) {
+	var keys []string
+	switch r.Method {
+	case http.MethodGet:
+		keys = r.URL.Query()["key"]
+	case http.MethodPost:
+		var in struct {
+			Keys []string `json:"keys"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRESTValueBytes)).Decode(&in); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+		keys = in.Keys
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if len(keys) == 0 || len(keys) > maxBatchKeys {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("expect 1 to %d keys", maxBatchKeys))
+		return
+	}
+	out := BatchResponse{Values: make(map[string][]byte), Errors: make(map[string]string)}
+	for _, key := range keys {
+		view, err := group.GetContext(r.Context(), key)
+		if err != nil {
+			out.Errors[key] = err.Error()
+			continue
+		}
+		out.Values[key] = view.b
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// writeRESTError 把 Group 返回的错误转换为 HTTP 状态码
+func writeRESTError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrGroupRemoved):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrOriginOverloaded):
+		code = http.StatusServiceUnavailable
+	case errors.Is(err, ErrValueTooLarge):
+		code = http.StatusRequestEntityTooLarge
+	case errors.Is(err, context.DeadlineExceeded):
+		code = http.StatusGatewayTimeout
+	}
+	writeJSONError(w, code, err.Error())
+}
+
+// etagOf 根据值和 Content-Type 计算强 ETag
+func etagOf(v ByteView) string {
+	h := fnv.New64a()
+	h.Write(v.b)
+	h.Write([]byte{0})
+	h.Write([]byte(v.ct))
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// etagMatch 判断 If-None-Match 是否包含 etag，按弱比较处理 W/ 前缀
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
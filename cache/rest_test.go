@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func restDo(h http.Handler, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRESTHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewGroupOpts("scores", GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		}
+		return []byte("v-" + key), nil
+	}))
+	h := NewRESTHandler(r, "")
+
+	rec := restDo(h, "GET", "/v1/groups/scores/keys/Tom", "", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "v-Tom" || rec.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Fatalf("GET = %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	etag := rec.Header().Get("ETag")
+	rec = restDo(h, "GET", "/v1/groups/scores/keys/Tom", "", map[string]string{"If-None-Match": `"x", ` + etag})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("GET with If-None-Match = %d", rec.Code)
+	}
+
+	rec = restDo(h, "PUT", "/v1/groups/scores/keys/a/b", `{"n":1}`, map[string]string{
+		"Content-Type": "application/json",
+		ttlHeader:      "60",
+	})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT = %d %s", rec.Code, rec.Body.String())
+	}
+	rec = restDo(h, "GET", "/v1/groups/scores/keys/a/b", "", nil)
+	if rec.Body.String() != `{"n":1}` || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GET after PUT = %q %v", rec.Body.String(), rec.Header())
+	}
+	if ttl := rec.Header().Get(ttlHeader); ttl != "60" && ttl != "59" {
+		t.Fatalf("%s = %q", ttlHeader, ttl)
+	}
+	if rec.Header().Get("Expires") == "" {
+		t.Fatal("expect Expires header")
+	}
+
+	rec = restDo(h, "DELETE", "/v1/groups/scores/keys/a/b", "", nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d", rec.Code)
+	}
+	if rec = restDo(h, "GET", "/v1/groups/scores/keys/a/b", "", nil); rec.Body.String() != "v-a/b" {
+		t.Fatalf("GET after DELETE should load from getter, got %q", rec.Body.String())
+	}
+
+	rec = restDo(h, "POST", "/v1/groups/scores/keys", `{"keys": ["Tom", "missing"]}`, nil)
+	var batch BatchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &batch); err != nil {
+		t.Fatal(err)
+	}
+	if string(batch.Values["Tom"]) != "v-Tom" || batch.Errors["missing"] == "" {
+		t.Fatalf("batch = %+v", batch)
+	}
+	rec = restDo(h, "GET", "/v1/groups/scores/keys?key=Tom&key=Jack", "", nil)
+	batch = BatchResponse{}
+	json.Unmarshal(rec.Body.Bytes(), &batch)
+	if len(batch.Values) != 2 {
+		t.Fatalf("batch = %+v", batch)
+	}
+
+	for _, c := range []struct {
+		method, path, ttl string
+		code              int
+	}{
+		{"GET", "/v1/groups/scores/keys/missing", "", http.StatusNotFound},
+		{"GET", "/v1/groups/nope/keys/k", "", http.StatusNotFound},
+		{"GET", "/v1/groups/scores", "", http.StatusNotFound},
+		{"PUT", "/v1/groups/scores/keys/k", "abc", http.StatusBadRequest},
+		{"POST", "/v1/groups/scores/keys/k", "", http.StatusMethodNotAllowed},
+	} {
+		header := map[string]string{}
+		if c.ttl != "" {
+			header[ttlHeader] = c.ttl
+		}
+		rec := restDo(h, c.method, c.path, "", header)
+		var body map[string]string
+		if rec.Code != c.code || json.Unmarshal(rec.Body.Bytes(), &body) != nil || body["error"] == "" {
+			t.Fatalf("%s %s = %d %q, expect %d with JSON error", c.method, c.path, rec.Code, rec.Body.String(), c.code)
+		}
+	}
+}
+
+func TestRESTWriteToOwner(t *testing.T) {
+	nodes := newTestCluster(t, 2, HTTPPoolOptions{})
+	a, b := nodes[0], nodes[1]
+	// 找一个 owner 为 b 的 key
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprint("k", i)
+		if peer, ok := a.pool.PickPeer(key); ok && peer.(*httpGetter).peer == b.url() {
+			break
+		}
+	}
+	ha := NewRESTHandler(a.pool.opts.Registry, "")
+	hb := NewRESTHandler(b.pool.opts.Registry, "")
+
+	rec := restDo(ha, "PUT", "/v1/groups/group/keys/"+key, "hello", map[string]string{"Content-Type": "text/plain"})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT = %d %s", rec.Code, rec.Body.String())
+	}
+	for _, h := range []http.Handler{hb, ha} {
+		rec = restDo(h, "GET", "/v1/groups/group/keys/"+key, "", nil)
+		if rec.Body.String() != "hello" || rec.Header().Get("Content-Type") != "text/plain" {
+			t.Fatalf("GET = %q %v", rec.Body.String(), rec.Header())
+		}
+	}
+	if *a.loads != 0 || *b.loads != 0 {
+		t.Fatalf("value written through the API should not be loaded, loads = %d %d", *a.loads, *b.loads)
+	}
+
+	if rec = restDo(ha, "DELETE", "/v1/groups/group/keys/"+key, "", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d", rec.Code)
+	}
+	if rec = restDo(ha, "GET", "/v1/groups/group/keys/"+key, "", nil); rec.Body.String() != "v-"+key || *b.loads != 1 {
+		t.Fatalf("GET after DELETE = %q, loads = %d", rec.Body.String(), *b.loads)
+	}
+}
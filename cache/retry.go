@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetryBackoff = 50 * time.Millisecond
+	maxRetryBackoff     = 5 * time.Second
+	maxRetries          = 10
+)
+
+// Retry 是访问节点时的重试策略，HTTPPool 和 cache/client 共用，保证两边的行为一致
+type Retry struct {
+	// MaxRetries 最大重试次数，0 表示不重试
+	MaxRetries int
+	// Backoff 重试的基础等待时间，第 n 次重试随机等待 [0, Backoff*2^(n-1))，区间上限最多增长到 5s
+	Backoff time.Duration
+}
+
+// NewRetry 创建 Retry，retries 限制在 [0, 10]，backoff 不大于 0 时为 50ms
+func NewRetry(retries int, backoff time.Duration) Retry {
+	r := Retry{MaxRetries: retries, Backoff: backoff}
+	if r.MaxRetries < 0 {
+		r.MaxRetries = 0
+	} else if r.MaxRetries > maxRetries {
+		r.MaxRetries = maxRetries
+	}
+	if r.Backoff <= 0 {
+		r.Backoff = defaultRetryBackoff
+	}
+	return r
+}
+
+// Do 调用 try，直到成功、错误不可重试、重试次数用完或 ctx 结束，返回最后一次的错误。
+// 每次重试前随机等待 Wait(attempt)，onRetry 不为 nil 时在等待前调用
+func (r Retry) Do(ctx context.Context, try func() (retry bool, err error), onRetry func(wait time.Duration, err error)) error {
+	for attempt := 0; ; attempt++ {
+		retry, err := try()
+		if err == nil || !retry || attempt >= r.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+		wait := r.Wait(attempt) //full jitter，避免多个请求同时重试
+		if onRetry != nil {
+			onRetry(wait, err)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// Wait 返回第 attempt 次重试前的随机等待时间 [0, Backoff*2^attempt)，区间上限不超过 max(Backoff, 5s)
+func (r Retry) Wait(attempt int) time.Duration {
+	base := r.Backoff
+	if base <= 0 {
+		base = defaultRetryBackoff
+	}
+	limit := base
+	if limit < maxRetryBackoff {
+		limit = maxRetryBackoff
+	}
+	ceiling := base
+	for i := 0; i < attempt && ceiling < limit; i++ {
+		ceiling *= 2
+	}
+	if ceiling > limit {
+		ceiling = limit
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// RetryableStatus 判断节点返回的状态码是否可以重试。502 和 504 说明请求没有被对方处理完；
+// 500 通常是回源失败，503 是对方回源过载，重试只会增加对方的负担
+func RetryableStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusGatewayTimeout
+}
@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryWait(t *testing.T) {
+	for attempt := 0; attempt < 100; attempt++ {
+		if wait := NewRetry(0, 50*time.Millisecond).Wait(attempt); wait < 0 || wait >= maxRetryBackoff {
+			t.Fatalf("attempt %d waits %v, expect [0, %v)", attempt, wait, maxRetryBackoff)
+		}
+		if wait := NewRetry(0, time.Minute).Wait(attempt); wait < 0 || wait >= time.Minute {
+			t.Fatalf("attempt %d waits %v with a large base, expect [0, 1m)", attempt, wait)
+		}
+	}
+	for retries, expect := range map[int]int{-1: 0, 3: 3, 1000: maxRetries} {
+		if r := NewRetry(retries, 0); r.MaxRetries != expect || r.Backoff != defaultRetryBackoff {
+			t.Fatalf("NewRetry(%d, 0) = %+v, expect %d retries", retries, r, expect)
+		}
+		p := NewHTTPPoolOpts("self", &HTTPPoolOptions{MaxRetries: retries})
+		if p.opts.MaxRetries != expect {
+			t.Fatalf("MaxRetries %d = %d, expect %d", retries, p.opts.MaxRetries, expect)
+		}
+		p.Close()
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	r := NewRetry(2, time.Millisecond)
+	calls := 0
+	err := r.Do(context.Background(), func() (bool, error) {
+		calls++
+		return true, errors.New("bad gateway")
+	}, nil)
+	if err == nil || calls != 3 {
+		t.Fatalf("Do = %v after %d calls, expect 1 attempt and 2 retries", err, calls)
+	}
+
+	calls = 0
+	r.Do(context.Background(), func() (bool, error) {
+		calls++
+		return false, errors.New("origin failed")
+	}, nil)
+	if calls != 1 {
+		t.Fatalf("non-retryable error was retried, calls = %d", calls)
+	}
+
+	for code, retry := range map[int]bool{500: false, 502: true, 503: false, 504: true} {
+		if RetryableStatus(code) != retry {
+			t.Fatalf("RetryableStatus(%d) = %v", code, !retry)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	forwardedByHeader = "X-Gocache-Forwarded-By"
+	ringVersionHeader = "X-Gocache-Ring-Version"
+)
+
+// ringVersion 根据节点列表及权重计算哈希环的版本，节点列表相同的两个节点版本相同
+func ringVersion(weights map[string]int) uint64 {
+	peers := make([]string, 0, len(weights))
+	for peer := range weights {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	h := fnv.New64a()
+	for _, peer := range peers {
+		fmt.Fprintf(h, "%s %d\n", peer, weights[peer])
+	}
+	return h.Sum64()
+}
+
+// ringCheck 记录本机哈希环的版本，并统计与其他节点交换版本时发现的不一致
+type ringCheck struct {
+	mu         sync.Mutex
+	version    uint64
+	logged     map[string][2]uint64 //每个节点最近一次记录日志时的 [本机版本, 对方版本]，同样的不一致只记录一次
+	mismatches AtomicInt
+	log        func(format string, v ...interface{})
+}
+
+func (r *ringCheck) set(version uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.version = version
+}
+
+func (r *ringCheck) header() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strconv.FormatUint(r.version, 16)
+}
+
+// observe 比较 peer 发来的版本，不一致时计数并记录日志。对方没有带版本时忽略
+func (r *ringCheck) observe(peer, remote string) {
+	if remote == "" {
+		return
+	}
+	v, err := strconv.ParseUint(remote, 16, 64)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	local := r.version
+	if v == local {
+		r.mu.Unlock()
+		return
+	}
+	pair := [2]uint64{local, v}
+	first := r.logged[peer] != pair
+	if first {
+		if r.logged == nil {
+			r.logged = make(map[string][2]uint64)
+		}
+		r.logged[peer] = pair
+	}
+	r.mu.Unlock()
+
+	r.mismatches.Add(1)
+	if first {
+		r.log("ring version %x of peer %s differs from ours %x", v, peer, local)
+	}
+}
+
+// RingVersion 返回当前节点列表的版本，各节点节点列表一致时版本相同
+func (p *HTTPPool) RingVersion() uint64 {
+	p.ring.mu.Lock()
+	defer p.ring.mu.Unlock()
+	return p.ring.version
+}
+
+// RingMismatches 返回与其他节点交换版本时发现哈希环不一致的次数
+func (p *HTTPPool) RingMismatches() int64 {
+	return p.ring.mismatches.Get()
+}
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestForwardingLoop(t *testing.T) {
+	nodes := newTestCluster(t, 2, HTTPPoolOptions{Timeout: time.Second})
+	a, b := nodes[0], nodes[1]
+	// 两个节点都认为 key 属于对方
+	a.pool.Set(b.url())
+	b.pool.Set(a.url())
+	if a.pool.RingVersion() == b.pool.RingVersion() {
+		t.Fatal("different peer lists should have different ring versions")
+	}
+
+	if v, err := a.group.Get("key"); err != nil || v.String() != "v-key" {
+		t.Fatalf("Get = %q, %v", v.String(), err)
+	}
+	if *a.loads != 0 || *b.loads != 1 {
+		t.Fatalf("forwarded request should be served by the receiver, loads = %d %d", *a.loads, *b.loads)
+	}
+	if b.group.Stats().PeerLoads.Get() != 0 {
+		t.Fatal("forwarded request should not be forwarded again")
+	}
+	if a.pool.RingMismatches() != 1 || b.pool.RingMismatches() != 1 {
+		t.Fatalf("RingMismatches = %d %d, expect both sides to notice", a.pool.RingMismatches(), b.pool.RingMismatches())
+	}
+
+	// 节点列表一致后不再计数
+	a.pool.Set(a.url(), b.url())
+	b.pool.Set(a.url(), b.url())
+	if a.pool.RingVersion() != b.pool.RingVersion() {
+		t.Fatal("same peer lists should have the same ring version")
+	}
+	for i := 0; i < 30; i++ {
+		if _, err := a.group.Get(fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.group.Stats().PeerLoads.Get() == 0 || a.pool.RingMismatches() != 1 {
+		t.Fatalf("PeerLoads = %v, RingMismatches = %d", &a.group.Stats().PeerLoads, a.pool.RingMismatches())
+	}
+}
@@ -0,0 +1,475 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-tools/codec"
+	"go-tools/consistenthash"
+	pb "go-tools/gocachepb"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	rpcGetMethod       = "GroupCache.Get"
+	rpcSetMethod       = "GroupCache.Set"
+	defaultRPCTimeout  = 5 * time.Second
+	defaultDialTimeout = time.Second
+)
+
+var ErrConnClosed = errors.New("gocache: rpc connection closed")
+
+var _ PeerPicker = (*RPCPool)(nil)
+var _ ReplicaPicker = (*RPCPool)(nil)
+var _ PeerGetter = (*rpcGetter)(nil)
+var _ PeerSetter = (*rpcGetter)(nil)
+var _ PeerContextGetter = (*rpcGetter)(nil)
+
+// RPCPool 是 HTTPPool 的替代，节点之间通过 codec 包的 RPC 报文通信：
+// 连接建立时发送 codec.Option，之后每个请求是 | Header | Body |，Body 为 protobuf 编码的 []byte。
+// 每个远程节点保持一条长连接，并发的请求按 Seq 在同一条连接上流水线发送
+type RPCPool struct {
+	self    string
+	opts    RPCPoolOptions
+	mu      sync.Mutex
+	peers   *consistenthash.Map
+	getters map[string]*rpcGetter
+}
+
+// RPCPoolOptions 是 RPCPool 的可选配置，零值字段使用默认值
+type RPCPoolOptions struct {
+	// Replicas 一致性哈希中每个节点的虚拟节点数，默认 50
+	Replicas int
+	// HashFn 一致性哈希函数，默认 crc32.ChecksumIEEE
+	HashFn consistenthash.Hash
+	// Registry 服务端查找 Group 的 Registry，默认 DefaultRegistry
+	Registry *Registry
+	// ReplicationFactor 每个 key 的 owner 数量（主节点加副本），默认 1 即不复制
+	ReplicationFactor int
+	// Timeout 单次请求的超时时间，默认 5s
+	Timeout time.Duration
+	// DialTimeout 建立连接的超时时间，默认 1s
+	DialTimeout time.Duration
+	// CodecType 使用的编码，默认 codec.GobType
+	CodecType codec.Type
+}
+
+// NewRPCPool 创建 RPCPool，o 为 nil 时使用默认配置
+func NewRPCPool(self string, o *RPCPoolOptions) *RPCPool {
+	p := &RPCPool{self: self, getters: make(map[string]*rpcGetter)}
+	if o != nil {
+		p.opts = *o
+	}
+	if p.opts.Replicas == 0 {
+		p.opts.Replicas = defaultReplicas
+	}
+	if p.opts.Registry == nil {
+		p.opts.Registry = DefaultRegistry
+	}
+	if p.opts.ReplicationFactor < 1 {
+		p.opts.ReplicationFactor = 1
+	}
+	if p.opts.Timeout <= 0 {
+		p.opts.Timeout = defaultRPCTimeout
+	}
+	if p.opts.DialTimeout <= 0 {
+		p.opts.DialTimeout = defaultDialTimeout
+	}
+	if p.opts.CodecType == "" {
+		p.opts.CodecType = codec.GobType
+	}
+	return p
+}
+
+func (p *RPCPool) Log(format string, v ...interface{}) {
+	log.Printf("[RPC %s] %s", p.self, fmt.Sprintf(format, v...))
+}
+
+// Set 更新节点列表，已有节点的连接保持不变，被移除节点的连接会被关闭
+func (p *RPCPool) Set(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sorted := append([]string(nil), peers...)
+	sort.Strings(sorted) //按固定顺序添加，保证各节点的哈希环一致
+
+	getters := make(map[string]*rpcGetter, len(sorted))
+	for _, peer := range sorted {
+		if g, ok := p.getters[peer]; ok {
+			getters[peer] = g
+		} else if peer != p.self {
+			getters[peer] = &rpcGetter{addr: peer, self: p.self, opts: &p.opts}
+		}
+	}
+	for peer, g := range p.getters {
+		if _, ok := getters[peer]; !ok {
+			g.close()
+		}
+	}
+	p.getters = getters
+	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	p.peers.Add(sorted...)
+}
+
+// Close 关闭到所有节点的连接
+func (p *RPCPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, g := range p.getters {
+		g.close()
+	}
+	p.getters = make(map[string]*rpcGetter)
+	p.peers = nil
+	return nil
+}
+
+// PickPeer 选择 key 的 owner，owner 是本机节点时返回 false
+func (p *RPCPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+	if peer := p.peers.Get(key); peer != "" && peer != p.self {
+		p.Log("Ready to Pick peer %s", peer)
+		return p.getters[peer], true
+	}
+	return nil, false
+}
+
+// PickReplicas 返回 key 在哈希环上的前 ReplicationFactor 个节点
+func (p *RPCPool) PickReplicas(key string) ([]PeerGetter, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, -1
+	}
+	self := -1
+	owners := make([]PeerGetter, 0, p.opts.ReplicationFactor)
+	for _, peer := range p.peers.GetN(key, p.opts.ReplicationFactor) {
+		if peer == p.self {
+			self = len(owners)
+			owners = append(owners, nil)
+		} else {
+			owners = append(owners, p.getters[peer])
+		}
+	}
+	return owners, self
+}
+
+// Accept 在 lis 上接受其他节点的连接，直到 lis 被关闭
+func (p *RPCPool) Accept(lis net.Listener) error {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		go p.ServeConn(conn)
+	}
+}
+
+// ServeConn 处理一条连接上的所有请求，请求并发执行，响应按完成顺序写回
+func (p *RPCPool) ServeConn(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	var opt codec.Option
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
+		p.Log("rpc server: options error: %v", err)
+		return
+	}
+	if opt.MagicNumber != codec.MagicNumber {
+		p.Log("rpc server: invalid magic number %x", opt.MagicNumber)
+		return
+	}
+	f := codec.NewCodecFuncMap[opt.CodecType]
+	if f == nil {
+		p.Log("rpc server: invalid codec type %s", opt.CodecType)
+		return
+	}
+	//json.Decoder 会多读数据，剩下的部分连同 Encoder 写在 Option 之后的换行符一起交给 codec 前先跳过空白
+	r := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+		if b[0] != '\n' && b[0] != '\r' && b[0] != ' ' && b[0] != '\t' {
+			break
+		}
+		r.ReadByte()
+	}
+	cc := f(&bufferedConn{Reader: r, ReadWriteCloser: conn})
+
+	sending := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+	for {
+		var h codec.Header
+		if err := cc.ReadHeader(&h); err != nil {
+			break
+		}
+		var body []byte
+		if err := cc.ReadBody(&body); err != nil {
+			break
+		}
+		wg.Add(1)
+		go func(h codec.Header) {
+			defer wg.Done()
+			ctx := context.Background()
+			if h.From != "" { //其他节点转发来的请求总是在本机处理
+				ctx = withLocalOnly(ctx)
+			}
+			reply, err := p.handle(ctx, h.ServiceMethod, body)
+			if err != nil {
+				h.Error = err.Error()
+				reply = []byte{}
+			}
+			sending.Lock()
+			defer sending.Unlock()
+			if err := cc.Write(&h, reply); err != nil {
+				p.Log("rpc server: write response error: %v", err)
+			}
+		}(h)
+	}
+	wg.Wait()
+	cc.Close()
+}
+
+func (p *RPCPool) handle(ctx context.Context, method string, body []byte) ([]byte, error) {
+	switch method {
+	case rpcGetMethod:
+		in := &pb.Request{}
+		if err := proto.Unmarshal(body, in); err != nil {
+			return nil, err
+		}
+		group := p.opts.Registry.GetGroup(in.GetGroup())
+		if group == nil {
+			return nil, fmt.Errorf("no such group: %s", in.GetGroup())
+		}
+		view, err := group.GetContext(ctx, in.GetKey())
+		if err != nil {
+			return nil, err
+		}
+		return proto.Marshal(view.response())
+	case rpcSetMethod:
+		in := &pb.SetRequest{}
+		if err := proto.Unmarshal(body, in); err != nil {
+			return nil, err
+		}
+		group := p.opts.Registry.GetGroup(in.GetGroup())
+		if group == nil {
+			return nil, fmt.Errorf("no such group: %s", in.GetGroup())
+		}
+		if err := group.setLocally(in.GetKey(), viewOf(in.GetValue(), in.GetExpire(), in.GetContentType())); err != nil {
+			return nil, err
+		}
+		return proto.Marshal(&pb.SetResponse{})
+	}
+	return nil, fmt.Errorf("unknown method: %s", method)
+}
+
+// bufferedConn 让 codec 从 Reader 读取，从原连接写入和关闭
+type bufferedConn struct {
+	io.Reader
+	io.ReadWriteCloser
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.Reader.Read(b)
+}
+
+// rpcGetter 是访问某个远程节点的客户端，连接断开后下一次请求时重新建立
+type rpcGetter struct {
+	addr   string
+	self   string
+	opts   *RPCPoolOptions
+	mu     sync.Mutex
+	client *rpcClient
+	closed bool
+}
+
+func (g *rpcGetter) Get(in *pb.Request, out *pb.Response) error {
+	return g.GetContext(context.Background(), in, out)
+}
+
+// GetContext 同 Get，ctx 被取消时不再等待响应
+func (g *rpcGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return g.call(ctx, rpcGetMethod, in, out)
+}
+
+func (g *rpcGetter) Set(in *pb.SetRequest, out *pb.SetResponse) error {
+	return g.call(context.Background(), rpcSetMethod, in, out)
+}
+
+func (g *rpcGetter) call(ctx context.Context, method string, in, out proto.Message) error {
+	c, err := g.conn()
+	if err != nil {
+		return err
+	}
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, g.opts.Timeout)
+	defer cancel()
+	reply, err := c.call(ctx, &codec.Header{ServiceMethod: method, From: g.self}, body)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(reply, out)
+}
+
+// conn 返回可用的连接，没有时建立一条新的
+func (g *rpcGetter) conn() (*rpcClient, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return nil, ErrConnClosed
+	}
+	if g.client != nil && g.client.available() {
+		return g.client, nil
+	}
+	c, err := dialRPC(g.addr, g.opts)
+	if err != nil {
+		return nil, err
+	}
+	g.client = c
+	return c, nil
+}
+
+func (g *rpcGetter) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+	if g.client != nil {
+		g.client.close(ErrConnClosed)
+	}
+}
+
+// rpcCall 是一个等待响应的请求
+type rpcCall struct {
+	reply []byte
+	err   error
+	done  chan struct{}
+}
+
+// rpcClient 是一条到远程节点的连接，请求按 Seq 与响应对应，多个请求可以同时在途
+type rpcClient struct {
+	cc      codec.Codec
+	sending sync.Mutex //保证一个请求的 Header 和 Body 连续写入
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]*rpcCall
+	err     error //不为 nil 时连接已不可用
+}
+
+func dialRPC(addr string, o *RPCPoolOptions) (*rpcClient, error) {
+	conn, err := net.DialTimeout("tcp", addr, o.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	f := codec.NewCodecFuncMap[o.CodecType]
+	if f == nil {
+		conn.Close()
+		return nil, fmt.Errorf("invalid codec type %s", o.CodecType)
+	}
+	opt := codec.Option{MagicNumber: codec.MagicNumber, CodecType: o.CodecType}
+	if err := json.NewEncoder(conn).Encode(&opt); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &rpcClient{cc: f(conn), pending: make(map[uint64]*rpcCall)}
+	go c.receive()
+	return c, nil
+}
+
+func (c *rpcClient) available() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err == nil
+}
+
+func (c *rpcClient) call(ctx context.Context, h *codec.Header, body []byte) ([]byte, error) {
+	call := &rpcCall{done: make(chan struct{})}
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.seq++
+	seq := c.seq
+	h.Seq = seq
+	c.pending[seq] = call
+	c.mu.Unlock()
+
+	c.sending.Lock()
+	err := c.cc.Write(h, body)
+	c.sending.Unlock()
+	if err != nil {
+		c.close(err)
+	}
+
+	select {
+	case <-call.done:
+		return call.reply, call.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, seq)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// receive 读取响应并交给对应的请求，连接出错时结束所有在途请求
+func (c *rpcClient) receive() {
+	var err error
+	for err == nil {
+		var h codec.Header
+		if err = c.cc.ReadHeader(&h); err != nil {
+			break
+		}
+		var reply []byte
+		if err = c.cc.ReadBody(&reply); err != nil {
+			break
+		}
+		c.mu.Lock()
+		call := c.pending[h.Seq]
+		delete(c.pending, h.Seq)
+		c.mu.Unlock()
+		if call == nil { //请求已经超时
+			continue
+		}
+		if h.Error != "" {
+			call.err = errors.New(h.Error)
+		} else {
+			call.reply = reply
+		}
+		close(call.done)
+	}
+	c.close(err)
+}
+
+func (c *rpcClient) close(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if err == nil || err == io.EOF {
+		err = ErrConnClosed
+	}
+	c.err = err
+	c.cc.Close()
+	for seq, call := range c.pending {
+		call.err = err
+		close(call.done)
+		delete(c.pending, seq)
+	}
+}
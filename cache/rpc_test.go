@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "go-tools/gocachepb"
+)
+
+// countingListener 统计建立的连接数
+type countingListener struct {
+	net.Listener
+	conns int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.conns, 1)
+	}
+	return conn, err
+}
+
+func startRPCNode(t *testing.T, getter Getter) (*RPCPool, *Group, *countingListener) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := &countingListener{Listener: l}
+	r := NewRegistry()
+	pool := NewRPCPool(l.Addr().String(), &RPCPoolOptions{Registry: r, Timeout: time.Second})
+	group, _ := r.NewGroupOpts("group", getter, WithPeerPicker(pool))
+	go pool.Accept(lis)
+	t.Cleanup(func() {
+		lis.Close()
+		pool.Close()
+	})
+	return pool, group, lis
+}
+
+func TestRPCPool(t *testing.T) {
+	var loads int32
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("v-" + key), nil
+	})
+	var pools []*RPCPool
+	var groups []*Group
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		pool, group, _ := startRPCNode(t, getter)
+		pools, groups, addrs = append(pools, pool), append(groups, group), append(addrs, pool.self)
+	}
+	for _, pool := range pools {
+		pool.Set(addrs...)
+	}
+
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprint(i)
+		for _, group := range groups {
+			if v, err := group.Get(key); err != nil || v.String() != "v-"+key {
+				t.Fatalf("Get(%s) = %q, %v", key, v.String(), err)
+			}
+		}
+	}
+	if loads != 30 {
+		t.Fatalf("each key should be loaded once by its owner, loads = %d", loads)
+	}
+	if groups[0].Stats().PeerLoads.Get() == 0 {
+		t.Fatal("expect some keys to be loaded from peers")
+	}
+}
+
+func TestRPCForwardedLocalOnly(t *testing.T) {
+	var loads [2]int32
+	a, ga, _ := startRPCNode(t, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads[0], 1)
+		return []byte("v-" + key), nil
+	}))
+	b, _, _ := startRPCNode(t, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads[1], 1)
+		return []byte("v-" + key), nil
+	}))
+	// 两个节点的哈希环不一致，都认为对方是 owner，转发来的请求不能再转发回去
+	a.Set(b.self)
+	b.Set(a.self)
+	if v, err := ga.Get("key"); err != nil || v.String() != "v-key" {
+		t.Fatalf("Get = %q, %v", v.String(), err)
+	}
+	if loads[0] != 0 || loads[1] != 1 {
+		t.Fatalf("the forwarded request should be served by b, loads = %d %d", loads[0], loads[1])
+	}
+}
+
+func TestRPCPipelining(t *testing.T) {
+	const n = 5
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	release := make(chan struct{})
+	server, _, lis := startRPCNode(t, GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, fmt.Errorf("no such key")
+		}
+		arrived.Done()
+		<-release //所有请求都到达服务端后才返回，证明它们同时在途
+		return []byte(key), nil
+	}))
+	client := NewRPCPool("client", nil)
+	defer client.Close()
+	client.Set(server.self)
+	peer, ok := client.PickPeer("key")
+	if !ok {
+		t.Fatal("expect a remote peer")
+	}
+
+	go func() {
+		arrived.Wait()
+		close(release)
+	}()
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			out := &pb.Response{}
+			err := peer.Get(&pb.Request{Group: "group", Key: key}, out)
+			if err == nil && string(out.GetValue()) != key {
+				err = fmt.Errorf("Get(%s) = %q", key, out.GetValue())
+			}
+			errs <- err
+		}(fmt.Sprint(i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c := atomic.LoadInt32(&lis.conns); c != 1 {
+		t.Fatalf("concurrent Gets should share one connection, conns = %d", c)
+	}
+
+	// 服务端的错误通过 Header.Error 返回，不影响连接
+	if err := peer.Get(&pb.Request{Group: "group", Key: "missing"}, &pb.Response{}); err == nil {
+		t.Fatal("expect error for a missing key")
+	}
+	if err := peer.Get(&pb.Request{Group: "group", Key: "0"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if c := atomic.LoadInt32(&lis.conns); c != 1 {
+		t.Fatalf("errors should not close the connection, conns = %d", c)
+	}
+}
@@ -0,0 +1,340 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	leavePath          = "_leave"
+	handoffConcurrency = 8
+)
+
+// drainer 记录正在处理的节点间请求，closing 之后拒绝新请求并等待已有请求完成
+type drainer struct {
+	mu       sync.Mutex
+	closing  bool
+	inflight int
+	idle     chan struct{} //closing 之后 inflight 归零时关闭
+}
+
+// enter 开始处理一个请求，已经开始关闭时返回 false
+func (d *drainer) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closing {
+		return false
+	}
+	d.inflight++
+	return true
+}
+
+func (d *drainer) leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.inflight--; d.inflight == 0 && d.closing {
+		close(d.idle)
+	}
+}
+
+// close 拒绝之后的请求，返回的 chan 在已有请求都完成后关闭
+func (d *drainer) close() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.closing {
+		d.closing = true
+		d.idle = make(chan struct{})
+		if d.inflight == 0 {
+			close(d.idle)
+		}
+	}
+	return d.idle
+}
+
+// Shutdown 让本机节点平滑退出：拒绝新的节点间请求（包括健康检查），通知其他节点把本机从哈希环中去掉
+// （需要开启请求签名或 mutual TLS，否则其他节点拒绝退出通知），
+// 等待正在处理的请求完成，HandoffKeys 大于 0 时再把每个 Group 最近访问的记录推送给去掉本机后的新 owner，
+// 最后关闭 HTTPPool。ctx 到期时放弃还没有完成的步骤并返回 ctx 的错误。
+// Shutdown 不关闭 http.Server，调用方应在它返回后再调用 http.Server.Shutdown
+func (p *HTTPPool) Shutdown(ctx context.Context) error {
+	defer p.Close()
+	idle := p.drain.close()
+	p.announceLeave(ctx)
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		return fmt.Errorf("gocache: draining peer requests: %w", ctx.Err())
+	}
+	if p.opts.HandoffKeys > 0 {
+		for _, name := range p.opts.Registry.ListGroups() {
+			if g := p.opts.Registry.GetGroup(name); g != nil {
+				p.handoff(ctx, g, p.opts.HandoffKeys)
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// announceLeave 并发通知所有远程节点本机即将退出，失败只记录日志
+func (p *HTTPPool) announceLeave(ctx context.Context) {
+	p.mu.Lock()
+	peers := make([]string, 0, len(p.httpGetters))
+	for peer := range p.httpGetters {
+		if peer != p.self {
+			peers = append(peers, peer)
+		}
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			if err := p.sendLeave(ctx, peer); err != nil {
+				p.Log("announce leave to %s fail: %v", peer, err)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+func (p *HTTPPool) sendLeave(ctx context.Context, peer string) error {
+	if p.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.opts.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+p.basePath+leavePath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(forwardedByHeader, p.self)
+	if err := p.signer.sign(req, nil); err != nil {
+		return err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+// serveLeave 处理其他节点的退出通知，在 LeaveSuspension 内把它从哈希环中去掉
+func (p *HTTPPool) serveLeave(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := p.signer.verify(request, nil); err != nil {
+		p.Log("reject leave notice: %v", err)
+		http.Error(writer, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	from := request.Header.Get(forwardedByHeader)
+	if err := p.checkLeaver(request, from); err != nil {
+		p.Log("reject leave notice from %s: %v", from, err)
+		http.Error(writer, err.Error(), http.StatusForbidden)
+		return
+	}
+	if from == p.self || !p.suspend(from) {
+		http.Error(writer, "unknown peer: "+from, http.StatusBadRequest)
+		return
+	}
+	p.Log("peer %s is leaving", from)
+	writer.Write([]byte("ok"))
+}
+
+// checkLeaver 确认退出通知来自 from 本身：使用 mutual TLS 时对方的客户端证书必须属于 from 的主机，
+// 否则请求必须带有有效签名（签名覆盖 forwardedByHeader）。两者都没有时任何人都能把节点移出哈希环，所以拒绝
+func (p *HTTPPool) checkLeaver(request *http.Request, from string) error {
+	if request.TLS != nil && len(request.TLS.PeerCertificates) > 0 {
+		u, err := url.Parse(from)
+		if err != nil {
+			return err
+		}
+		if err := request.TLS.PeerCertificates[0].VerifyHostname(u.Hostname()); err != nil {
+			return fmt.Errorf("client certificate does not belong to %s: %v", from, err)
+		}
+		return nil
+	}
+	if !p.signer.enabled() {
+		return errors.New("leave notice requires request signing or mutual TLS")
+	}
+	return nil
+}
+
+// leftPeer 是主动退出后暂时移出哈希环的节点
+type leftPeer struct {
+	weight int
+	until  time.Time
+}
+
+// suspend 把主动退出的节点从哈希环中去掉，它的 key 由环上的下一个节点接替。
+// 节点在 LeaveSuspension 之后、主动探测成功时或重新出现在 Set 或 SetWeighted 的列表中时恢复
+func (p *HTTPPool) suspend(peer string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w, ok := p.weights[peer]
+	if !ok {
+		return false
+	}
+	if p.left == nil {
+		p.left = make(map[string]leftPeer)
+	}
+	p.left[peer] = leftPeer{weight: w, until: time.Now().Add(p.opts.LeaveSuspension)}
+	weights := make(map[string]int, len(p.weights))
+	for k, w := range p.weights {
+		if k != peer {
+			weights[k] = w
+		}
+	}
+	p.setWeighted(weights)
+	return true
+}
+
+// rejoin 把退出的节点以原来的权重加回哈希环，调用方需持有 p.mu
+func (p *HTTPPool) rejoin(peers ...string) {
+	weights := make(map[string]int, len(p.weights)+len(peers))
+	for k, w := range p.weights {
+		weights[k] = w
+	}
+	for _, peer := range peers {
+		if l, ok := p.left[peer]; ok {
+			weights[peer] = l.weight
+			delete(p.left, peer)
+			p.Log("peer %s rejoins the ring", peer)
+		}
+	}
+	p.setWeighted(weights)
+}
+
+// rejoinExpired 把超过 LeaveSuspension 的退出节点加回哈希环，调用方需持有 p.mu
+func (p *HTTPPool) rejoinExpired() {
+	if len(p.left) == 0 {
+		return
+	}
+	now := time.Now()
+	var expired []string
+	for peer, l := range p.left {
+		if !now.Before(l.until) {
+			expired = append(expired, peer)
+		}
+	}
+	if len(expired) > 0 {
+		p.rejoin(expired...)
+	}
+}
+
+// leftPeers 返回暂时移出哈希环的退出节点
+func (p *HTTPPool) leftPeers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]string, 0, len(p.left))
+	for peer := range p.left {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// handoff 把 g 中最近访问的至多 n 条本机作为 owner 的记录推送给去掉本机后新增的 owner
+func (p *HTTPPool) handoff(ctx context.Context, g *Group, n int) {
+	start := time.Now()
+	sent, failed := p.pushEntries(ctx, g, n, func(key string) []*httpGetter {
+		if target := p.successor(key); target != nil {
+			return []*httpGetter{target}
+		}
+		return nil
+	}, nil)
+	p.Log("handoff group %s: %d entries sent, %d failed in %v", g.name, sent, failed, time.Since(start))
+}
+
+// pushEntries 从最近访问的记录开始，把 g 中至多 n 条（n 为 0 时不限）记录推送给 targets 返回的节点，
+// 没有目标节点的记录跳过。bucket 不为 nil 时每次推送前从中取一个令牌
+func (p *HTTPPool) pushEntries(ctx context.Context, g *Group, n int, targets func(key string) []*httpGetter,
+	bucket *tokenBucket) (sent, failed int64) {
+	keys, values := g.mainCache.entries()
+	type push struct {
+		key    string
+		value  ByteView
+		target *httpGetter
+	}
+	pushes := make(chan push)
+	var wg sync.WaitGroup
+	var sentN, failedN AtomicInt
+	for i := 0; i < handoffConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for push := range pushes {
+				req := push.value.setRequest(g.name, push.key)
+				if err := push.target.set(ctx, req); err != nil {
+					failedN.Add(1)
+					continue
+				}
+				sentN.Add(1)
+				g.stats.MovedPushes.Add(1)
+			}
+		}()
+	}
+
+	count := 0
+loop:
+	for i := len(keys) - 1; i >= 0 && (n == 0 || count < n); i-- { //entries 从旧到新排列
+		ts := targets(keys[i])
+		if len(ts) == 0 {
+			continue
+		}
+		value, _, err := g.decode(keys[i], values[i])
+		if err != nil {
+			continue
+		}
+		count++
+		for _, target := range ts {
+			if bucket != nil && bucket.wait(ctx) != nil {
+				break loop
+			}
+			select {
+			case pushes <- push{key: keys[i], value: value, target: target}:
+			case <-ctx.Done():
+				break loop
+			}
+		}
+	}
+	close(pushes)
+	wg.Wait()
+	return sentN.Get(), failedN.Get()
+}
+
+// successor 返回本机退出后接替本机成为 key 的 owner 的节点，本机不是 owner 或没有健康的节点可以接替时返回 nil
+func (p *HTTPPool) successor(key string) *httpGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil
+	}
+	owner := false
+	for i, peer := range p.peers.GetN(key, len(p.httpGetters)) {
+		if peer == p.self {
+			owner = i < p.opts.ReplicationFactor
+			continue
+		}
+		if i < p.opts.ReplicationFactor || !p.health.healthy(peer) { //原有的 owner 已经有这个值
+			continue
+		}
+		if owner {
+			return p.httpGetters[peer]
+		}
+		return nil
+	}
+	return nil
+}
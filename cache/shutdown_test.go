@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	secret := []byte("secret")
+	nodes := newTestCluster(t, 3, HTTPPoolOptions{HandoffKeys: 1000, SigningSecrets: [][]byte{secret}})
+	a, b, c := nodes[0], nodes[1], nodes[2]
+
+	// c 拥有的 key 在 c 上加载并缓存
+	var owned []string
+	for i := 0; len(owned) < 20; i++ {
+		key := fmt.Sprint("key", i)
+		if peer, ok := a.pool.PickPeer(key); ok && peer.(*httpGetter).peer == c.url() {
+			owned = append(owned, key)
+			if _, err := a.group.Get(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// 一个正在处理的请求阻塞在回源上
+	entered, release := make(chan struct{}), make(chan struct{})
+	c.pool.opts.Registry.NewGroupOpts("slow", GetterFunc(func(key string) ([]byte, error) {
+		close(entered)
+		<-release
+		return []byte("slow"), nil
+	}))
+	inflight := make(chan int)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, c.url()+defaultBasePath+"slow/key", nil)
+		SignRequest(req, nil, secret)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			inflight <- 0
+			return
+		}
+		res.Body.Close()
+		inflight <- res.StatusCode
+	}()
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- c.pool.Shutdown(ctx) }()
+
+	for {
+		res, err := http.Get(c.url() + defaultBasePath + healthPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusServiceUnavailable {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v before the in-flight request finished", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if code := <-inflight; code != http.StatusOK {
+		t.Fatalf("in-flight request = %d, expect 200", code)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, node := range []*testNode{a, b} {
+		if _, ok := node.pool.PeerStatuses()[c.url()]; ok {
+			t.Fatalf("%s still has the departed node in its ring", node.url())
+		}
+	}
+	loads := atomic.LoadInt32(a.loads) + atomic.LoadInt32(b.loads)
+	for _, key := range owned {
+		if v, err := a.group.Get(key); err != nil || v.String() != "v-"+key {
+			t.Fatalf("Get(%s) = %s, %v", key, v.String(), err)
+		}
+	}
+	if n := atomic.LoadInt32(a.loads) + atomic.LoadInt32(b.loads) - loads; n != 0 {
+		t.Fatalf("%d keys loaded from origin again, expect all handed off", n)
+	}
+}
+
+func TestShutdownRestart(t *testing.T) {
+	nodes := newTestCluster(t, 3, HTTPPoolOptions{HealthCheckInterval: 10 * time.Millisecond, SigningSecrets: [][]byte{[]byte("secret")}})
+	a, c := nodes[0], nodes[2]
+	key := ""
+	for i := 0; key == ""; i++ {
+		if peer, ok := a.pool.PickPeer(fmt.Sprint(i)); ok && peer.(*httpGetter).peer == c.url() {
+			key = fmt.Sprint(i)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.pool.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	owner := func() string {
+		if peer, ok := a.pool.PickPeer(key); ok {
+			return peer.(*httpGetter).peer
+		}
+		return a.url()
+	}
+	//退出中的节点健康检查返回 503，不会被加回哈希环
+	time.Sleep(50 * time.Millisecond)
+	if o := owner(); o == c.url() {
+		t.Fatal("departed node should be out of the ring")
+	}
+
+	//同一个地址上重新启动
+	c.srv.Close()
+	ln, err := net.Listen("tcp", strings.TrimPrefix(c.url(), "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewHTTPPoolOpts(c.url(), &HTTPPoolOptions{Registry: NewRegistry(), SigningSecrets: [][]byte{[]byte("secret")}})
+	defer restarted.Close()
+	srv := &httptest.Server{Listener: ln, Config: &http.Server{Handler: restarted}}
+	srv.Start()
+	defer srv.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for owner() != c.url() {
+		if time.Now().After(deadline) {
+			t.Fatal("restarted node should rejoin the ring after a successful probe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, ok := a.pool.PeerStatuses()[c.url()]; !ok {
+		t.Fatal("restarted node should be reported again")
+	}
+}
+
+func TestLeaveIdentity(t *testing.T) {
+	nodes := newTestCluster(t, 3, HTTPPoolOptions{})
+	a, b := nodes[0], nodes[1]
+	//没有签名也没有 mutual TLS 时，任何人都可以冒充其他节点，退出通知被拒绝
+	req, _ := http.NewRequest(http.MethodPost, a.url()+defaultBasePath+leavePath, nil)
+	req.Header.Set(forwardedByHeader, b.url())
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("unauthenticated leave notice returned %d", res.StatusCode)
+	}
+	if _, ok := a.pool.PeerStatuses()[b.url()]; !ok {
+		t.Fatal("peer should stay in the ring")
+	}
+
+	//开启签名后，篡改签名请求中的节点地址会使签名失效
+	a.pool.SetSigningSecrets([]byte("secret"))
+	req, _ = http.NewRequest(http.MethodPost, a.url()+defaultBasePath+leavePath, nil)
+	req.Header.Set(forwardedByHeader, nodes[2].url())
+	SignRequest(req, nil, []byte("secret"))
+	req.Header.Set(forwardedByHeader, b.url())
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("leave notice with a tampered sender returned %d", res.StatusCode)
+	}
+}
+
+func TestLeaveSuspension(t *testing.T) {
+	nodes := newTestCluster(t, 2, HTTPPoolOptions{LeaveSuspension: 30 * time.Millisecond})
+	a, b := nodes[0], nodes[1]
+	if !a.pool.suspend(b.url()) {
+		t.Fatal("suspend a known peer")
+	}
+	if _, ok := a.pool.PeerStatuses()[b.url()]; ok {
+		t.Fatal("suspended peer should be out of the ring")
+	}
+	time.Sleep(40 * time.Millisecond)
+	picked := false
+	for i := 0; i < 100 && !picked; i++ {
+		_, picked = a.pool.PickPeer(fmt.Sprint(i))
+	}
+	if !picked {
+		t.Fatal("peer should rejoin after LeaveSuspension")
+	}
+
+	//Set 给出的列表优先，不再恢复之前退出的节点
+	a.pool.suspend(b.url())
+	a.pool.Set(a.url())
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := a.pool.PickPeer("key"); ok {
+		t.Fatal("peer removed by Set should not rejoin")
+	}
+}
+
+func TestDrainer(t *testing.T) {
+	var d drainer
+	if !d.enter() {
+		t.Fatal("enter before close")
+	}
+	idle := d.close()
+	if d.enter() {
+		t.Fatal("enter after close")
+	}
+	select {
+	case <-idle:
+		t.Fatal("idle before the request left")
+	default:
+	}
+	d.leave()
+	<-idle
+	<-d.close()
+}
@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	signatureHeader     = "X-Gocache-Signature"
+	timestampHeader     = "X-Gocache-Timestamp"
+	nonceHeader         = "X-Gocache-Nonce"
+	defaultReplayWindow = 30 * time.Second
+)
+
+var (
+	ErrBadSignature = errors.New("gocache: bad request signature")
+	ErrReplayed     = errors.New("gocache: request timestamp outside replay window or nonce reused")
+)
+
+// signedHeaders 是会改变服务端行为的 header，都包含在签名中，篡改后签名失效
+var signedHeaders = []string{forwardedByHeader, cacheOnlyHeader, ringVersionHeader}
+
+// requestSigner 用共享密钥对节点之间的请求做 HMAC-SHA256 签名。
+// 签名覆盖 method、path、signedHeaders、时间戳、随机 nonce 和 body 的摘要；第一个密钥用于签名，所有密钥都可以通过验证，
+// 轮换时先在所有节点加入新密钥，再把它移到第一位，最后删除旧密钥。
+// 时间戳与本机时间相差超过 window 的请求被拒绝，window 内同一个 nonce 只能使用一次
+type requestSigner struct {
+	mu      sync.RWMutex
+	secrets [][]byte
+	window  time.Duration
+
+	nonceMu    sync.Mutex
+	nonces     map[string]struct{} //当前一代见过的 nonce
+	prevNonces map[string]struct{} //上一代，两代合起来至少覆盖 2*window
+	genStart   time.Time
+
+	now func() time.Time
+}
+
+func newRequestSigner(secrets [][]byte, window time.Duration) *requestSigner {
+	s := &requestSigner{window: window, now: time.Now}
+	s.setSecrets(secrets)
+	return s
+}
+
+func (s *requestSigner) setSecrets(secrets [][]byte) {
+	cp := make([][]byte, 0, len(secrets))
+	for _, secret := range secrets {
+		cp = append(cp, cloneBytes(secret))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets = cp
+}
+
+// enabled 返回是否配置了密钥
+func (s *requestSigner) enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.secrets) > 0
+}
+
+// sign 为请求加上签名相关的 header，没有配置密钥时什么也不做。signedHeaders 需要在 sign 之前设置好
+func (s *requestSigner) sign(req *http.Request, body []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.secrets) == 0 {
+		return nil
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(b[:])
+	ts := strconv.FormatInt(s.now().Unix(), 10)
+	req.Header.Set(timestampHeader, ts)
+	req.Header.Set(nonceHeader, nonce)
+	req.Header.Set(signatureHeader, hex.EncodeToString(mac(s.secrets[0], req, ts, nonce, body)))
+	return nil
+}
+
+// SignRequest 用 secret 为 req 加上签名，供不经过 HTTPPool 访问节点的客户端使用，body 为请求的完整 body
+func SignRequest(req *http.Request, body []byte, secret []byte) error {
+	return newRequestSigner([][]byte{secret}, defaultReplayWindow).sign(req, body)
+}
+
+// verify 检查请求的签名和时间戳，没有配置密钥时总是通过
+func (s *requestSigner) verify(req *http.Request, body []byte) error {
+	s.mu.RLock()
+	secrets := s.secrets
+	s.mu.RUnlock()
+	if len(secrets) == 0 {
+		return nil
+	}
+	ts, nonce := req.Header.Get(timestampHeader), req.Header.Get(nonceHeader)
+	sig, err := hex.DecodeString(req.Header.Get(signatureHeader))
+	if err != nil || len(sig) == 0 || nonce == "" {
+		return ErrBadSignature
+	}
+	ok := false
+	for _, secret := range secrets {
+		if hmac.Equal(sig, mac(secret, req, ts, nonce, body)) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return ErrBadSignature
+	}
+
+	now := s.now()
+	if err := s.checkTimestamp(ts, now); err != nil {
+		return err
+	}
+	if !s.useNonce(nonce, now) {
+		return ErrReplayed
+	}
+	return nil
+}
+
+// precheck 在读取 body 之前检查签名相关的 header 是否齐全、时间戳是否在 window 内，
+// 让明显无效的请求不必上传 body。签名和 nonce 仍由 verify 检查，没有配置密钥时总是通过
+func (s *requestSigner) precheck(req *http.Request) error {
+	s.mu.RLock()
+	n := len(s.secrets)
+	s.mu.RUnlock()
+	if n == 0 {
+		return nil
+	}
+	sig, err := hex.DecodeString(req.Header.Get(signatureHeader))
+	if err != nil || len(sig) == 0 || req.Header.Get(nonceHeader) == "" {
+		return ErrBadSignature
+	}
+	return s.checkTimestamp(req.Header.Get(timestampHeader), s.now())
+}
+
+func (s *requestSigner) checkTimestamp(ts string, now time.Time) error {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > s.window || d < -s.window {
+		return ErrReplayed
+	}
+	return nil
+}
+
+// useNonce 记录 nonce，已经见过时返回 false
+func (s *requestSigner) useNonce(nonce string, now time.Time) bool {
+	s.nonceMu.Lock()
+	defer s.nonceMu.Unlock()
+	if s.nonces == nil || now.Sub(s.genStart) > 2*s.window {
+		s.prevNonces, s.nonces = s.nonces, make(map[string]struct{})
+		s.genStart = now
+	}
+	if _, ok := s.nonces[nonce]; ok {
+		return false
+	}
+	if _, ok := s.prevNonces[nonce]; ok {
+		return false
+	}
+	s.nonces[nonce] = struct{}{}
+	return true
+}
+
+func mac(secret []byte, req *http.Request, ts, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	h := hmac.New(sha256.New, secret)
+	parts := []string{req.Method, req.URL.EscapedPath()}
+	for _, name := range signedHeaders {
+		parts = append(parts, name+":"+req.Header.Get(name))
+	}
+	for _, part := range append(parts, ts, nonce, hex.EncodeToString(sum[:])) {
+		h.Write([]byte(part))
+		h.Write([]byte{'\n'})
+	}
+	return h.Sum(nil)
+}
+
+// SetSigningSecrets 替换请求签名的密钥，第一个用于签名，全部用于验证。不传参数时关闭签名
+func (p *HTTPPool) SetSigningSecrets(secrets ...[]byte) {
+	p.signer.setSecrets(secrets)
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pb "go-tools/gocachepb"
+	"google.golang.org/protobuf/proto"
+)
+
+func newSignTestPool(secrets ...[]byte) *HTTPPool {
+	r := NewRegistry()
+	r.NewGroup("sign", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	return NewHTTPPoolOpts("", &HTTPPoolOptions{Registry: r, SigningSecrets: secrets})
+}
+
+func signedRequest(t *testing.T, method string, secrets ...[]byte) *http.Request {
+	req := httptest.NewRequest(method, "/_gocache/sign/key", nil)
+	if err := newRequestSigner(secrets, time.Minute).sign(req, nil); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func serveCode(pool *HTTPPool, req *http.Request) int {
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRequestSigning(t *testing.T) {
+	oldSecret, newSecret := []byte("old secret"), []byte("new secret")
+	pool := newSignTestPool(oldSecret)
+
+	if code := serveCode(pool, httptest.NewRequest(http.MethodGet, "/_gocache/sign/key", nil)); code != http.StatusUnauthorized {
+		t.Fatalf("unsigned request returned %d", code)
+	}
+	if code := serveCode(pool, signedRequest(t, http.MethodGet, []byte("wrong"))); code != http.StatusUnauthorized {
+		t.Fatalf("request with a wrong secret returned %d", code)
+	}
+	if code := serveCode(pool, httptest.NewRequest(http.MethodGet, "/_gocache/"+healthPath, nil)); code != http.StatusOK {
+		t.Fatalf("health check returned %d", code)
+	}
+
+	// 篡改 path 后签名失效
+	req := signedRequest(t, http.MethodGet, oldSecret)
+	req.URL.Path = "/_gocache/sign/other"
+	if code := serveCode(pool, req); code != http.StatusUnauthorized {
+		t.Fatalf("tampered request returned %d", code)
+	}
+
+	// 篡改会改变服务端行为的 header 后签名失效
+	for _, name := range signedHeaders {
+		req := signedRequest(t, http.MethodGet, oldSecret)
+		req.Header.Set(name, "tampered")
+		if code := serveCode(pool, req); code != http.StatusUnauthorized {
+			t.Fatalf("request with tampered %s returned %d", name, code)
+		}
+	}
+
+	// 轮换：验证方同时接受新旧密钥
+	pool.SetSigningSecrets(newSecret, oldSecret)
+	if code := serveCode(pool, signedRequest(t, http.MethodGet, oldSecret)); code != http.StatusOK {
+		t.Fatalf("request signed with the old secret returned %d", code)
+	}
+	if code := serveCode(pool, signedRequest(t, http.MethodGet, newSecret)); code != http.StatusOK {
+		t.Fatalf("request signed with the new secret returned %d", code)
+	}
+	pool.SetSigningSecrets(newSecret)
+	if code := serveCode(pool, signedRequest(t, http.MethodGet, oldSecret)); code != http.StatusUnauthorized {
+		t.Fatalf("request signed with a retired secret returned %d", code)
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	secret := []byte("secret")
+	pool := newSignTestPool(secret)
+
+	req := signedRequest(t, http.MethodGet, secret)
+	if code := serveCode(pool, req); code != http.StatusOK {
+		t.Fatalf("first request returned %d", code)
+	}
+	if code := serveCode(pool, req); code != http.StatusUnauthorized {
+		t.Fatalf("replayed request returned %d", code)
+	}
+
+	req = signedRequest(t, http.MethodGet, secret)
+	pool.signer.now = func() time.Time { return time.Now().Add(time.Minute) }
+	if code := serveCode(pool, req); code != http.StatusUnauthorized {
+		t.Fatalf("stale request returned %d", code)
+	}
+}
+
+func TestSignedCluster(t *testing.T) {
+	nodes := newTestCluster(t, 2, HTTPPoolOptions{SigningSecrets: [][]byte{[]byte("secret")}})
+	for i := 0; i < 20; i++ {
+		key := string(rune('a' + i))
+		if v, err := nodes[0].group.Get(key); err != nil || v.String() != "v-"+key {
+			t.Fatalf("Get(%s) = %q, %v", key, v.String(), err)
+		}
+	}
+	if nodes[0].group.Stats().PeerLoads.Get() == 0 || nodes[0].group.Stats().PeerErrors.Get() != 0 {
+		t.Fatalf("signed peer loads = %v, errors = %v", &nodes[0].group.Stats().PeerLoads, &nodes[0].group.Stats().PeerErrors)
+	}
+}
+
+// readCounter 记录 body 被读取的字节数
+type readCounter struct {
+	r *bytes.Reader
+	n int
+}
+
+func (c *readCounter) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += n
+	return n, err
+}
+
+func TestSetBodyLimit(t *testing.T) {
+	secret := []byte("secret")
+	r := NewRegistry()
+	r.NewGroupOpts("sign", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithMaxValueBytes(16))
+	pool := NewHTTPPoolOpts("", &HTTPPoolOptions{Registry: r, SigningSecrets: [][]byte{secret}, MaxValueBytes: 2048})
+	put := func(value []byte, sign bool) (int, *readCounter) {
+		body, _ := proto.Marshal(&pb.SetRequest{Group: "sign", Key: "key", Value: value})
+		rc := &readCounter{r: bytes.NewReader(body)}
+		req := httptest.NewRequest(http.MethodPut, "/_gocache/sign/key", rc)
+		if sign {
+			newRequestSigner([][]byte{secret}, time.Minute).sign(req, body)
+		}
+		return serveCode(pool, req), rc
+	}
+
+	// 没有签名的 PUT 在读取 body 之前被拒绝
+	if code, rc := put([]byte("v"), false); code != http.StatusUnauthorized || rc.n != 0 {
+		t.Fatalf("unsigned PUT = %d after reading %d bytes, expect 401 before reading", code, rc.n)
+	}
+	// body 超过 MaxValueBytes 时最多读取上限加一个缓冲区
+	if code, rc := put(make([]byte, 1<<20), true); code != http.StatusRequestEntityTooLarge || rc.n > 64<<10 {
+		t.Fatalf("oversized PUT = %d after reading %d bytes, expect 413", code, rc.n)
+	}
+	// 超过 Group 的 WithMaxValueBytes 的值不写入缓存
+	if code, _ := put(make([]byte, 32), true); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("PUT over the group limit = %d, expect 413", code)
+	}
+	if _, ok := r.GetGroup("sign").lookupCache("key"); ok {
+		t.Fatal("oversized value should not be cached")
+	}
+	if code, _ := put([]byte("v"), true); code != http.StatusOK {
+		t.Fatalf("PUT = %d, expect 200", code)
+	}
+}
@@ -0,0 +1,251 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 快照格式:
+// | magic "GCSN"(4) | version(1) | entry... | 0(1) | crc32(4) |
+// entry: | 1(1) | keyLen(uvarint) | key | valueLen(uvarint) | value | expire unix nano(varint, 0 表示永不过期) |
+// 带 Content-Type 的 entry 以 2 开头，并在 expire 之后追加 | ctLen(uvarint) | ct |
+// entry 按 lru 从旧到新的顺序写入，Restore 依次 add 即可还原访问顺序；crc32 覆盖前面所有字节
+const (
+	snapshotMagic   = "GCSN"
+	snapshotVersion = 1
+
+	maxSnapshotKeyLen   = 1 << 16
+	maxSnapshotValueLen = 1 << 30
+)
+
+var ErrBadSnapshot = errors.New("gocache: bad snapshot")
+
+// Snapshot 把 mainCache 中未过期的数据写入 w。开启加密时写出的是密文，恢复时需要相同的 KeyProvider
+func (g *Group) Snapshot(w io.Writer) error {
+	keys, values := g.mainCache.entries()
+
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	bw.WriteString(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+	var buf [binary.MaxVarintLen64]byte
+	for i, key := range keys {
+		flag := byte(1)
+		if values[i].ct != "" {
+			flag = 2
+		}
+		bw.WriteByte(flag)
+		bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(key)))])
+		bw.WriteString(key)
+		bw.Write(buf[:binary.PutUvarint(buf[:], uint64(values[i].Len()))])
+		bw.Write(values[i].b)
+		var expire int64
+		if !values[i].e.IsZero() {
+			expire = values[i].e.UnixNano()
+		}
+		bw.Write(buf[:binary.PutVarint(buf[:], expire)])
+		if flag == 2 {
+			bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(values[i].ct)))])
+			bw.WriteString(values[i].ct)
+		}
+	}
+	bw.WriteByte(0)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	_, err := w.Write(sum[:])
+	return err
+}
+
+// Restore 从 r 读取 Snapshot 写出的数据并加入 mainCache，校验失败时不会写入任何数据，已过期的记录会被跳过
+func (g *Group) Restore(r io.Reader) error {
+	crc := crc32.NewIEEE()
+	br := &snapshotReader{r: bufio.NewReader(r), crc: crc}
+
+	magic := make([]byte, len(snapshotMagic)+1)
+	if err := br.read(magic); err != nil {
+		return err
+	}
+	if string(magic[:len(snapshotMagic)]) != snapshotMagic {
+		return fmt.Errorf("%w: invalid magic", ErrBadSnapshot)
+	}
+	if magic[len(snapshotMagic)] != snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, magic[len(snapshotMagic)])
+	}
+
+	var keys []string
+	var values []ByteView
+	for {
+		flag, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if flag == 0 {
+			break
+		}
+		key, err := br.readBytes(maxSnapshotKeyLen)
+		if err != nil {
+			return err
+		}
+		value, err := br.readBytes(maxSnapshotValueLen)
+		if err != nil {
+			return err
+		}
+		expire, err := binary.ReadVarint(br)
+		if err != nil {
+			return br.wrap(err)
+		}
+		var ct []byte
+		if flag == 2 {
+			if ct, err = br.readBytes(maxSnapshotKeyLen); err != nil {
+				return err
+			}
+		}
+		v := viewOf(value, expire, string(ct))
+		keys = append(keys, string(key))
+		values = append(values, v)
+	}
+
+	want := crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(br.r, sum[:]); err != nil {
+		return br.wrap(err)
+	}
+	if binary.BigEndian.Uint32(sum[:]) != want {
+		return fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	now := time.Now()
+	for i, key := range keys {
+		if !values[i].expired(now) {
+			g.mainCache.add(key, values[i])
+		}
+	}
+	g.logger.Printf("[gocache] group %s restored %d entries from snapshot \n", g.name, len(keys))
+	return nil
+}
+
+// SaveSnapshotFile 把快照原子地写入 path（先写临时文件再 rename）
+func (g *Group) SaveSnapshotFile(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := g.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadSnapshotFile 用于启动时加载快照，文件不存在时直接返回 nil
+func (g *Group) LoadSnapshotFile(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return g.Restore(f)
+}
+
+// Warm 以最多 concurrency 个并发调用 Getter 预热 keys，已在缓存中的 key 会被跳过。
+// 返回成功加载的数量和遇到的第一个错误
+func (g *Group) Warm(keys []string, concurrency int) (int, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		loaded   int
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for _, key := range keys {
+		if _, ok := g.mainCache.get(key); ok || key == "" {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			_, err := g.loader.Do(key, func() (interface{}, error) {
+				return g.getLocally(context.Background(), key)
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				loaded++
+			} else if firstErr == nil {
+				firstErr = err
+			}
+		}(key)
+	}
+	wg.Wait()
+	return loaded, firstErr
+}
+
+// snapshotReader 在读取的同时计算 crc32
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (s *snapshotReader) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0, s.wrap(err)
+	}
+	s.crc.Write([]byte{b})
+	return b, nil
+}
+
+func (s *snapshotReader) read(p []byte) error {
+	if _, err := io.ReadFull(s.r, p); err != nil {
+		return s.wrap(err)
+	}
+	s.crc.Write(p)
+	return nil
+}
+
+func (s *snapshotReader) readBytes(max uint64) ([]byte, error) {
+	n, err := binary.ReadUvarint(s)
+	if err != nil {
+		return nil, s.wrap(err)
+	}
+	if n > max {
+		return nil, fmt.Errorf("%w: length %d too large", ErrBadSnapshot, n)
+	}
+	p := make([]byte, n)
+	return p, s.read(p)
+}
+
+func (s *snapshotReader) wrap(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: unexpected end of data", ErrBadSnapshot)
+	}
+	return err
+}
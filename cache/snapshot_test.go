@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v-" + key), nil
+	})
+	src, _ := NewRegistry().NewGroup("snapshot-src", 2<<10, getter)
+	for _, k := range []string{"k1", "k2", "k3"} {
+		src.Get(k)
+	}
+	src.Get("k1") //k1 最近访问
+	src.mainCache.add("expired", ByteView{b: []byte("x"), e: time.Now().Add(-time.Second)})
+	expire := time.Now().Add(time.Hour).Round(0)
+	src.mainCache.add("ttl", ByteView{b: []byte("y"), e: expire})
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, _ := NewRegistry().NewGroup("snapshot-dst", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s should be restored", key)
+	}))
+	if err := dst.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	keys, values := dst.mainCache.entries()
+	if fmt.Sprint(keys) != "[k2 k3 k1 ttl]" {
+		t.Fatalf("restored keys in wrong order: %v", keys)
+	}
+	if !values[3].Expire().Equal(expire) {
+		t.Fatalf("expire = %v, expect %v", values[3].Expire(), expire)
+	}
+	if v, err := dst.Get("k2"); err != nil || v.String() != "v-k2" {
+		t.Fatalf("Get k2 = %q, %v", v.String(), err)
+	}
+
+	corrupt := buf.Bytes()
+	corrupt[len(corrupt)-6] ^= 0xff
+	if err := dst.Restore(bytes.NewReader(corrupt)); !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("expect ErrBadSnapshot, got %v", err)
+	}
+	if err := dst.Restore(bytes.NewReader(corrupt[:10])); !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("expect ErrBadSnapshot for truncated data, got %v", err)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	var inflight, peak, calls int32
+	g, _ := NewRegistry().NewGroup("warm", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		if key == "bad" {
+			return nil, fmt.Errorf("bad key")
+		}
+		return []byte(key), nil
+	}))
+	g.Get("a")
+
+	keys := []string{"a", "b", "c", "d", "e", "f", "bad"}
+	loaded, err := g.Warm(keys, 2)
+	if loaded != 5 || err == nil {
+		t.Fatalf("Warm = %d, %v; expect 5 and an error", loaded, err)
+	}
+	if peak > 2 {
+		t.Fatalf("concurrency %d exceeds limit 2", peak)
+	}
+	if calls != 7 {
+		t.Fatalf("getter called %d times, expect 7", calls)
+	}
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"reflect"
+	"strconv"
+	"sync/atomic"
+)
+
+// AtomicInt 是可以并发读写的 int64 计数器
+type AtomicInt int64
+
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Stats 是 Group 的统计数据
+type Stats struct {
+	Gets          AtomicInt // 所有 Get 请求，包括来自其他节点的
+	CacheHits     AtomicInt // mainCache 或 hotCache 命中
+	HotCacheHits  AtomicInt // hotCache 命中
+	PeerLoads     AtomicInt // 从其他节点取回（远程加载或远程缓存命中）
+	PeerErrors    AtomicInt // 从其他节点取值失败
+	Loads         AtomicInt // Gets - CacheHits
+	LoadsDeduped  AtomicInt // singleflight 合并后实际执行的加载
+	LocalLoads    AtomicInt // 调用 Getter 成功
+	LocalLoadErrs AtomicInt // 调用 Getter 失败
+	OriginRejects AtomicInt // 回源排队已满被拒绝
+
+	ReplicaPushes   AtomicInt // 推送给副本节点成功
+	ReplicaPushErrs AtomicInt // 推送给副本节点失败
+
+	Hedges     AtomicInt // 远程读取超过延迟阈值后发出的对冲请求
+	HedgeWins  AtomicInt // 对冲请求先于原请求成功返回
+	HedgeSkips AtomicInt // 超过延迟阈值但预算不足，没有发出对冲请求
+
+	MovedPushes AtomicInt // 哈希环变化或本机退出时推送给新 owner 的记录
+	MovedHits   AtomicInt // 过渡期内从 key 之前的 owner 取回的记录
+
+	PeerBytesSent  AtomicInt // 响应其他节点的 Get 时写出的字节数（压缩后）
+	PeerBytesSaved AtomicInt // 响应其他节点的 Get 时 gzip 压缩节省的字节数
+}
+
+// Snapshot 以字段名为 key 返回所有计数器当前的值
+func (s *Stats) Snapshot() map[string]int64 {
+	out := make(map[string]int64)
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if c, ok := v.Field(i).Addr().Interface().(*AtomicInt); ok {
+			out[v.Type().Field(i).Name] = c.Get()
+		}
+	}
+	return out
+}
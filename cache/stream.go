@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	pb "go-tools/gocachepb"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// streamContentType 是分块传输的响应类型，请求方在 Accept 中带上它表示可以接收分块响应
+	streamContentType = "application/x-gocache-chunks"
+	// streamChunkSize 每个 Chunk 的数据长度，不超过它的值仍然用一个 pb.Response 传输
+	streamChunkSize = 64 << 10
+	maxChunkMessage = streamChunkSize + 1<<10
+	// maxChunkPrealloc 读取整个流时最多预分配的内存
+	maxChunkPrealloc = 4 << 20
+)
+
+var (
+	ErrValueTooLarge = errors.New("gocache: value too large")
+	ErrBadChunk      = errors.New("gocache: bad chunk stream")
+)
+
+// PeerStreamer 是 PeerGetter 的可选扩展，以流的方式读取远程节点上的值，不需要把整个值读入内存
+type PeerStreamer interface {
+	GetStream(ctx context.Context, in *pb.Request) (io.ReadCloser, error)
+}
+
+// writeChunks 把 v 按 streamChunkSize 分块写入 w，直接引用 v 的数据，不复制整个值
+func writeChunks(w io.Writer, v ByteView) error {
+	bw := bufio.NewWriter(w)
+	var lenBuf [binary.MaxVarintLen64]byte
+	write := func(c *pb.Chunk) error {
+		b, err := proto.Marshal(c)
+		if err != nil {
+			return err
+		}
+		bw.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(b)))])
+		_, err = bw.Write(b)
+		return err
+	}
+
+	first := &pb.Chunk{Size: int64(len(v.b)), ContentType: v.ct}
+	if !v.e.IsZero() {
+		first.Expire = v.e.UnixNano()
+	}
+	for off := 0; off < len(v.b); off += streamChunkSize {
+		end := off + streamChunkSize
+		if end > len(v.b) {
+			end = len(v.b)
+		}
+		c := &pb.Chunk{Offset: int64(off), Data: v.b[off:end]}
+		if off == 0 {
+			first.Data = c.Data
+			c = first
+		}
+		if err := write(c); err != nil {
+			return err
+		}
+	}
+	last := &pb.Chunk{Offset: int64(len(v.b)), Last: true, Checksum: crc32.ChecksumIEEE(v.b)}
+	if len(v.b) == 0 {
+		first.Last, first.Checksum = true, last.Checksum
+		last = first
+	}
+	if err := write(last); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// chunkReader 从 writeChunks 写出的流中依次读出数据，读完最后一个 Chunk 后校验长度和校验和
+type chunkReader struct {
+	r      *bufio.Reader
+	max    int64 //大于 0 时拒绝超过该长度的值
+	first  *pb.Chunk
+	data   []byte //当前 Chunk 中还没有被读走的数据
+	offset int64
+	crc    hash.Hash32
+	err    error
+}
+
+func newChunkReader(r io.Reader, max int64) *chunkReader {
+	return &chunkReader{r: bufio.NewReader(r), max: max, crc: crc32.NewIEEE()}
+}
+
+// header 读取第一个 Chunk，返回整个值的长度、过期时间和 Content-Type
+func (c *chunkReader) header() (*pb.Chunk, error) {
+	if c.first == nil && c.err == nil {
+		c.next()
+	}
+	return c.first, c.err
+}
+
+// next 读取下一个 Chunk 并检查偏移，最后一个 Chunk 检查校验和后把 err 置为 io.EOF
+func (c *chunkReader) next() {
+	n, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		c.err = fmt.Errorf("%w: %v", ErrBadChunk, err)
+		return
+	}
+	if n > maxChunkMessage {
+		c.err = fmt.Errorf("%w: chunk of %d bytes", ErrBadChunk, n)
+		return
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		c.err = fmt.Errorf("%w: %v", ErrBadChunk, err)
+		return
+	}
+	chunk := &pb.Chunk{}
+	if err := proto.Unmarshal(b, chunk); err != nil {
+		c.err = fmt.Errorf("%w: %v", ErrBadChunk, err)
+		return
+	}
+	if c.first == nil {
+		if chunk.GetSize() < 0 || (c.max > 0 && chunk.GetSize() > c.max) {
+			c.err = fmt.Errorf("%w: %d bytes", ErrValueTooLarge, chunk.GetSize())
+			return
+		}
+		c.first = chunk
+	}
+	if chunk.GetOffset() != c.offset || c.offset+int64(len(chunk.GetData())) > c.first.GetSize() {
+		c.err = fmt.Errorf("%w: unexpected chunk at offset %d", ErrBadChunk, chunk.GetOffset())
+		return
+	}
+	c.data = chunk.GetData()
+	c.offset += int64(len(c.data))
+	c.crc.Write(c.data)
+	if chunk.GetLast() {
+		switch {
+		case c.offset != c.first.GetSize():
+			c.err = fmt.Errorf("%w: got %d of %d bytes", ErrBadChunk, c.offset, c.first.GetSize())
+		case c.crc.Sum32() != chunk.GetChecksum():
+			c.err = fmt.Errorf("%w: checksum mismatch", ErrBadChunk)
+		default:
+			c.err = io.EOF
+		}
+	}
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.data) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.next()
+	}
+	n := copy(p, c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+// readChunks 把整个流读入 out。第一个 Chunk 声明的长度来自对方，只按它预分配不超过 maxChunkPrealloc 的内存，
+// 之后随着数据到达增长
+func readChunks(r io.Reader, out *pb.Response, max int64) error {
+	c := newChunkReader(r, max)
+	first, err := c.header()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	size := first.GetSize()
+	if size > maxChunkPrealloc {
+		size = maxChunkPrealloc
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if _, err := buf.ReadFrom(c); err != nil { //读到最后一个 Chunk 并通过长度和校验和检查时 c 返回 io.EOF
+		return err
+	}
+	out.Value, out.Expire, out.ContentType = buf.Bytes(), first.GetExpire(), first.GetContentType()
+	return nil
+}
+
+// acceptsChunks 判断请求方是否可以接收分块响应
+func acceptsChunks(accept string) bool {
+	return strings.Contains(accept, streamContentType)
+}
+
+// chunkReadCloser 关闭时同时关闭底层的响应体
+type chunkReadCloser struct {
+	*chunkReader
+	io.Closer
+}
+
+// GetReader 以 io.ReadCloser 的方式读取 key，适合很大的值：本机缓存命中时直接读取缓存中的值，
+// owner 是支持 PeerStreamer 的远程节点时边传输边读取，读完后校验完整性，这样取回的值不会写入 hotCache。
+// 其余情况同 GetContext。调用方需要关闭返回的 ReadCloser
+func (g *Group) GetReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	if rc, ok := g.streamFromOwner(ctx, key); ok {
+		return rc, nil
+	}
+	view, err := g.GetContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(view.Reader()), nil
+}
+
+// streamFromOwner 在 key 不在本机缓存中、主 owner 是支持 PeerStreamer 的远程节点时打开到它的流
+func (g *Group) streamFromOwner(ctx context.Context, key string) (io.ReadCloser, bool) {
+	if key == "" || g.peers == nil || isLocalOnly(ctx) {
+		return nil, false
+	}
+	if _, ok := g.mainCache.get(key); ok {
+		return nil, false
+	}
+	if _, ok := g.hotCache.get(key); ok {
+		return nil, false
+	}
+	owners, self := g.owners(key)
+	if self == 0 || len(owners) == 0 {
+		return nil, false
+	}
+	streamer, ok := owners[0].(PeerStreamer)
+	if !ok {
+		return nil, false
+	}
+	rc, err := streamer.GetStream(ctx, &pb.Request{Group: g.name, Key: key})
+	if err != nil {
+		g.stats.PeerErrors.Add(1)
+		g.logger.Printf("[gocache] stream %s from peer fail, the err: %v \n", key, err)
+		return nil, false
+	}
+	g.stats.Gets.Add(1)
+	g.stats.PeerLoads.Add(1)
+	return rc, true
+}
@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	pb "go-tools/gocachepb"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestChunks(t *testing.T) {
+	for _, size := range []int{0, 10, streamChunkSize, 3*streamChunkSize + 7} {
+		value := make([]byte, size)
+		rand.Read(value)
+		v := ByteView{b: value, e: time.Unix(100, 0), ct: "text/plain"}
+		var buf bytes.Buffer
+		if err := writeChunks(&buf, v); err != nil {
+			t.Fatal(err)
+		}
+		stream := buf.Bytes()
+
+		out := &pb.Response{}
+		if err := readChunks(bytes.NewReader(stream), out, 0); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(out.Value, value) || out.Expire != v.e.UnixNano() || out.ContentType != "text/plain" {
+			t.Fatalf("size %d: decoded value differs", size)
+		}
+		if size > 0 {
+			if err := readChunks(bytes.NewReader(stream), &pb.Response{}, int64(size-1)); !errors.Is(err, ErrValueTooLarge) {
+				t.Fatalf("size %d: expect ErrValueTooLarge, got %v", size, err)
+			}
+		}
+		if err := readChunks(bytes.NewReader(stream[:len(stream)-1]), &pb.Response{}, 0); !errors.Is(err, ErrBadChunk) {
+			t.Fatalf("size %d: truncated stream should fail, got %v", size, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	writeChunks(&buf, ByteView{b: bytes.Repeat([]byte("a"), 1000)})
+	corrupt := buf.Bytes()
+	corrupt[20] ^= 1
+	if err := readChunks(bytes.NewReader(corrupt), &pb.Response{}, 0); !errors.Is(err, ErrBadChunk) {
+		t.Fatalf("corrupted stream should fail the checksum, got %v", err)
+	}
+}
+
+func TestStreamLargeValue(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789"), 30<<10) //300KB，分成多个 Chunk
+	nodes := newTestCluster(t, 2, HTTPPoolOptions{})
+	a, b := nodes[0], nodes[1]
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprint("big", i)
+		if peer, ok := a.pool.PickPeer(key); ok && peer.(*httpGetter).peer == b.url() {
+			break
+		}
+	}
+	if err := b.group.set(key, ByteView{b: large}); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", b.url()+defaultBasePath+"group/"+key, nil)
+	req.Header.Set("Accept", streamContentType)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != streamContentType {
+		t.Fatalf("large value should be sent in chunks, Content-Type = %s", ct)
+	}
+
+	v, err := a.group.Get(key)
+	if err != nil || !bytes.Equal(v.b, large) {
+		t.Fatalf("Get = %d bytes, %v", v.Len(), err)
+	}
+
+	rc, err := a.group.GetReader(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, large) {
+		t.Fatalf("GetReader = %d bytes, %v", len(got), err)
+	}
+	if a.group.Stats().PeerLoads.Get() != 2 || *a.loads != 0 || *b.loads != 0 {
+		t.Fatalf("PeerLoads = %d, loads = %d %d", a.group.Stats().PeerLoads.Get(), *a.loads, *b.loads)
+	}
+
+	getter := a.pool.httpGetters[b.url()]
+	getter.maxSize = int64(len(large) - 1)
+	if _, err := getter.GetStream(context.Background(), &pb.Request{Group: "group", Key: key}); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("GetStream over MaxValueBytes = %v", err)
+	}
+	if err := getter.Get(&pb.Request{Group: "group", Key: key}, &pb.Response{}); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("Get over MaxValueBytes = %v", err)
+	}
+
+	// 本机缓存中的值直接读取
+	rc, _ = b.group.GetReader(context.Background(), key)
+	if n, _ := io.Copy(ioutil.Discard, rc); n != int64(len(large)) {
+		t.Fatalf("local GetReader = %d bytes", n)
+	}
+}
+
+func TestMaxValueBytes(t *testing.T) {
+	r := NewRegistry()
+	g, _ := r.NewGroupOpts("group", GetterFunc(func(key string) ([]byte, error) {
+		return make([]byte, len(key)), nil
+	}), WithMaxValueBytes(4))
+	if _, err := g.Get("1234"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Get("12345"); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("Get = %v, expect ErrValueTooLarge", err)
+	}
+	if err := g.set("k", ByteView{b: make([]byte, 5)}); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("set = %v, expect ErrValueTooLarge", err)
+	}
+}
+
+func TestStreamTimeout(t *testing.T) {
+	value := bytes.Repeat([]byte("0123456789"), 30<<10)
+	var buf bytes.Buffer
+	writeChunks(&buf, ByteView{b: value})
+	stream := buf.Bytes()
+	n, l := binary.Uvarint(stream)
+	first := l + int(n) //第一个 Chunk 及其长度前缀
+	_, peer := newClientTestPool(t, HTTPPoolOptions{Timeout: 50 * time.Millisecond}, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", streamContentType)
+		w.Write(stream[:first])
+		w.(http.Flusher).Flush()
+		time.Sleep(150 * time.Millisecond) //响应体的传输超过 Timeout
+		w.Write(stream[first:])
+	})
+
+	rc, err := peer.(PeerStreamer).GetStream(context.Background(), &pb.Request{Group: "group", Key: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, value) {
+		t.Fatalf("GetStream = %d bytes, %v", len(got), err)
+	}
+}
+
+func TestChunkPrealloc(t *testing.T) {
+	//声明的长度只影响预分配，不会按它一次分配 1TB，实际长度不符时失败
+	var forged bytes.Buffer
+	for _, c := range []*pb.Chunk{{Size: 1 << 40, Data: []byte("value")}, {Offset: 5, Last: true}} {
+		b, _ := proto.Marshal(c)
+		forged.Write(binary.AppendUvarint(nil, uint64(len(b))))
+		forged.Write(b)
+	}
+	if err := readChunks(&forged, &pb.Response{}, 0); !errors.Is(err, ErrBadChunk) {
+		t.Fatalf("expect ErrBadChunk for a stream shorter than the declared size, got %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package cache
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoCertificate = errors.New("gocache: no certificate")
+	// ErrNoRootCAs 表示没有用于验证其他节点的 CA。x509 在 Roots 为 nil 时使用系统 CA，任何公开签发的证书都能通过验证，所以拒绝
+	ErrNoRootCAs = errors.New("gocache: no root CAs for verifying peers")
+)
+
+// CertProvider 为 HTTPPool 提供本节点的证书和用于验证其他节点的 CA。
+// 每次 TLS 握手都会调用，实现可以随时替换证书而不需要重启
+type CertProvider interface {
+	Certificate() (*tls.Certificate, error)
+	RootCAs() (*x509.CertPool, error)
+}
+
+// CertStore 是一个内存中的 CertProvider，Update 替换证书和 CA。
+// 由 LoadCertFiles 创建时可以调用 Reload 或 Watch 从文件重新加载
+type CertStore struct {
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	roots *x509.CertPool
+
+	certFile, keyFile, caFile string
+	modTimes                  [3]time.Time
+	done                      chan struct{}
+	closeOnce                 sync.Once
+}
+
+// NewCertStore 创建 CertStore，roots 为 nil 时返回 ErrNoRootCAs
+func NewCertStore(cert tls.Certificate, roots *x509.CertPool) (*CertStore, error) {
+	s := &CertStore{done: make(chan struct{})}
+	if err := s.Update(cert, roots); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadCertFiles 从 PEM 文件加载本节点的证书、私钥和 CA
+func LoadCertFiles(certFile, keyFile, caFile string) (*CertStore, error) {
+	s := &CertStore{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		done:     make(chan struct{}),
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Update 替换证书和 CA，之后建立的连接使用新的证书。roots 为 nil 时返回 ErrNoRootCAs，保留原来的证书
+func (s *CertStore) Update(cert tls.Certificate, roots *x509.CertPool) error {
+	if roots == nil {
+		return ErrNoRootCAs
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cert = &cert
+	s.roots = roots
+	return nil
+}
+
+// Reload 重新读取 LoadCertFiles 给定的文件，任何一个文件不合法时保留原来的证书
+func (s *CertStore) Reload() error {
+	if s.certFile == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+	pem, err := ioutil.ReadFile(s.caFile)
+	if err != nil {
+		return fmt.Errorf("load CA: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("load CA: no certificates in %s", s.caFile)
+	}
+	return s.Update(cert, roots)
+}
+
+// Watch 每隔 interval 检查一次证书文件，修改时间变化时重新加载，直到 Close
+func (s *CertStore) Watch(interval time.Duration) {
+	s.modTimes = s.fileModTimes()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+			}
+			modTimes := s.fileModTimes()
+			if modTimes == s.modTimes {
+				continue
+			}
+			if err := s.Reload(); err != nil {
+				log.Printf("[gocache] reload certificates: %v, keep the current ones \n", err)
+				continue
+			}
+			s.modTimes = modTimes
+			log.Printf("[gocache] reloaded certificates from %s \n", s.certFile)
+		}
+	}()
+}
+
+func (s *CertStore) fileModTimes() (t [3]time.Time) {
+	for i, name := range []string{s.certFile, s.keyFile, s.caFile} {
+		if info, err := os.Stat(name); err == nil {
+			t[i] = info.ModTime()
+		}
+	}
+	return t
+}
+
+func (s *CertStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
+
+func (s *CertStore) Certificate() (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert == nil {
+		return nil, ErrNoCertificate
+	}
+	return s.cert, nil
+}
+
+func (s *CertStore) RootCAs() (*x509.CertPool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.roots, nil
+}
+
+// clientTLSConfig 返回访问主机 host 使用的 tls.Config：出示本节点证书，并用 CertProvider 当前的 CA 验证对方。
+// 标准库只在创建 tls.Config 时读取 RootCAs，为了让 CA 的更新立即生效，证书链和主机名在 VerifyConnection 中验证。
+// host 为空时使用握手的 ServerName，它对 IP 地址为空，此时拒绝连接
+func clientTLSConfig(cp CertProvider, host string) *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         host,
+		InsecureSkipVerify: true, //由 VerifyConnection 验证
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cp.Certificate()
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			name := host
+			if name == "" {
+				name = cs.ServerName
+			}
+			if name == "" {
+				return errors.New("gocache: no host name to verify the peer certificate against")
+			}
+			return verifyPeer(cp, cs, name, x509.ExtKeyUsageServerAuth)
+		},
+	}
+}
+
+// ServerTLSConfig 返回 HTTPPool 对外服务使用的 tls.Config，要求对方出示由 CA 签发的客户端证书。
+// 没有配置 TLS 时返回 nil
+func (p *HTTPPool) ServerTLSConfig() *tls.Config {
+	cp := p.opts.TLS
+	if cp == nil {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAnyClientCert, //由 VerifyConnection 验证
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cp.Certificate()
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return verifyPeer(cp, cs, "", x509.ExtKeyUsageClientAuth)
+		},
+	}
+}
+
+// ListenAndServeTLS 在 addr 上以 mutual TLS 提供服务
+func (p *HTTPPool) ListenAndServeTLS(addr string) error {
+	if p.opts.TLS == nil {
+		return ErrNoCertificate
+	}
+	srv := &http.Server{Addr: addr, Handler: p, TLSConfig: p.ServerTLSConfig()}
+	return srv.ListenAndServeTLS("", "")
+}
+
+// verifyPeer 用当前的 CA 验证对方的证书链，name 不为空时同时校验主机名
+func verifyPeer(cp CertProvider, cs tls.ConnectionState, name string, usage x509.ExtKeyUsage) error {
+	if len(cs.PeerCertificates) == 0 {
+		return ErrNoCertificate
+	}
+	roots, err := cp.RootCAs()
+	if err != nil {
+		return err
+	}
+	if roots == nil { //CertProvider 还没有加载 CA 时不能退回到系统 CA
+		return ErrNoRootCAs
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// ClientTLSConfig 返回用 cp 的证书访问节点的 tls.Config，供不经过 HTTPPool 访问节点的客户端使用。
+// 对方证书按 ServerName 校验，节点地址是 IP 时 ServerName 为空，应使用 TLSTransport
+func ClientTLSConfig(cp CertProvider) *tls.Config {
+	return clientTLSConfig(cp, "")
+}
+
+// TLSTransport 返回 t 的副本，每次建立连接时按拨号地址中的主机名（包括 IP）验证对方证书。
+// 经过代理的连接不使用 DialTLSContext，按 ClientTLSConfig 验证
+func TLSTransport(t *http.Transport, cp CertProvider) *http.Transport {
+	t = t.Clone()
+	t.TLSClientConfig = clientTLSConfig(cp, "")
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tc := tls.Client(conn, clientTLSConfig(cp, host))
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+	return t
+}
+
+// peerTransport 在 HTTPPool 配置了 TLS 时为 transport 加上客户端 TLS 配置
+func peerTransport(transport http.RoundTripper, cp CertProvider) http.RoundTripper {
+	if cp == nil {
+		return transport
+	}
+	t, ok := transport.(*http.Transport)
+	if !ok {
+		return transport //自定义的 RoundTripper 自己负责 TLS
+	}
+	return TLSTransport(t, cp)
+}
@@ -0,0 +1,256 @@
+package cache
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "go-tools/gocachepb"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gocache test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue 为 hosts（IP 或域名，默认 127.0.0.1）签发一个节点证书，可同时用于服务端和客户端
+func (ca *testCA) issue(t *testing.T, hosts ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "gocache node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if len(hosts) == 0 {
+		hosts = []string{"127.0.0.1"}
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newTestCertStore(t *testing.T, cert tls.Certificate, roots *x509.CertPool) *CertStore {
+	s, err := NewCertStore(cert, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newTLSServer(t *testing.T, certs CertProvider) *httptest.Server {
+	r := NewRegistry()
+	r.NewGroup("tls", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	pool := NewHTTPPoolOpts("", &HTTPPoolOptions{Registry: r, TLS: certs})
+	//StartTLS 会加上 httptest 自带的证书，这里自己包装 listener
+	srv := httptest.NewUnstartedServer(pool)
+	srv.Listener = tls.NewListener(srv.Listener, pool.ServerTLSConfig())
+	srv.Start()
+	srv.URL = strings.Replace(srv.URL, "http://", "https://", 1)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func tlsGet(t *testing.T, certs CertProvider, url string) error {
+	pool := NewHTTPPoolOpts("self", &HTTPPoolOptions{TLS: certs})
+	defer pool.Close()
+	pool.Set(url)
+	peer, ok := pool.PickPeer("key")
+	if !ok {
+		t.Fatal("expect a remote peer")
+	}
+	out := &pb.Response{}
+	err := peer.Get(&pb.Request{Group: "tls", Key: "key"}, out)
+	if err == nil && string(out.GetValue()) != "key" {
+		t.Fatalf("Get = %q", out.GetValue())
+	}
+	return err
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCerts := newTestCertStore(t, ca.issue(t), ca.pool)
+	srv := newTLSServer(t, serverCerts)
+
+	if err := tlsGet(t, newTestCertStore(t, ca.issue(t), ca.pool), srv.URL); err != nil {
+		t.Fatalf("Get with a trusted client certificate: %v", err)
+	}
+
+	other := newTestCA(t)
+	if err := tlsGet(t, newTestCertStore(t, other.issue(t), ca.pool), srv.URL); err == nil {
+		t.Fatal("client certificate from an unknown CA should be rejected")
+	}
+	if err := tlsGet(t, newTestCertStore(t, ca.issue(t), other.pool), srv.URL); err == nil {
+		t.Fatal("server certificate from an unknown CA should be rejected")
+	}
+
+	// 证书由可信的 CA 签发，但不属于所访问的地址
+	impostor := newTLSServer(t, newTestCertStore(t, ca.issue(t, "10.0.0.1", "other.example"), ca.pool))
+	if err := tlsGet(t, newTestCertStore(t, ca.issue(t), ca.pool), impostor.URL); err == nil {
+		t.Fatal("server certificate for another host should be rejected")
+	}
+
+	// 没有 CA 时不能退回到系统 CA
+	if _, err := NewCertStore(ca.issue(t), nil); !errors.Is(err, ErrNoRootCAs) {
+		t.Fatalf("NewCertStore without roots = %v", err)
+	}
+	if err := serverCerts.Update(ca.issue(t), nil); !errors.Is(err, ErrNoRootCAs) {
+		t.Fatalf("Update without roots = %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(ca.issue(t).Certificate[0])
+	cs := tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	if err := verifyPeer(noRoots{serverCerts}, cs, "127.0.0.1", x509.ExtKeyUsageServerAuth); !errors.Is(err, ErrNoRootCAs) {
+		t.Fatalf("verifyPeer without roots = %v", err)
+	}
+
+	// 非 TLS 请求被拒绝
+	w := httptest.NewRecorder()
+	NewHTTPPoolOpts("", &HTTPPoolOptions{Registry: NewRegistry(), TLS: serverCerts}).
+		ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_gocache/tls/key", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("plain HTTP request returned %d", w.Code)
+	}
+}
+
+func TestLeaveClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	pool := NewHTTPPoolOpts("https://127.0.0.1:1", &HTTPPoolOptions{Registry: NewRegistry(), TLS: newTestCertStore(t, ca.issue(t), ca.pool)})
+	defer pool.Close()
+	leaver := "https://10.0.0.1:1"
+	pool.Set(pool.self, leaver)
+	leave := func(host string) int {
+		cert, _ := x509.ParseCertificate(ca.issue(t, host).Certificate[0])
+		req := httptest.NewRequest(http.MethodPost, "/_gocache/"+leavePath, nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		req.Header.Set(forwardedByHeader, leaver)
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, req)
+		return w.Code
+	}
+	// 客户端证书属于其他节点时不能替 leaver 发出退出通知
+	if code := leave("127.0.0.1"); code != http.StatusForbidden {
+		t.Fatalf("leave notice with another node's certificate returned %d", code)
+	}
+	if code := leave("10.0.0.1"); code != http.StatusOK {
+		t.Fatalf("leave notice with the leaver's certificate returned %d", code)
+	}
+	if _, ok := pool.PeerStatuses()[leaver]; ok {
+		t.Fatal("leaver should be out of the ring")
+	}
+}
+
+// noRoots 模拟还没有加载 CA 的 CertProvider
+type noRoots struct{ CertProvider }
+
+func (noRoots) RootCAs() (*x509.CertPool, error) { return nil, nil }
+
+func TestCertReload(t *testing.T) {
+	oldCA, newCA := newTestCA(t), newTestCA(t)
+	both := x509.NewCertPool()
+	both.AddCert(oldCA.cert)
+	both.AddCert(newCA.cert)
+
+	dir, err := ioutil.TempDir("", "gocache-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	writeCerts := func(cert tls.Certificate, cas ...*testCA) {
+		key, _ := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+		ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)
+		ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+		var caPEM []byte
+		for _, ca := range cas {
+			caPEM = append(caPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+		}
+		ioutil.WriteFile(caFile, caPEM, 0600)
+	}
+
+	writeCerts(oldCA.issue(t), oldCA)
+	serverCerts, err := LoadCertFiles(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTLSServer(t, serverCerts)
+	if err := tlsGet(t, newTestCertStore(t, oldCA.issue(t), oldCA.pool), srv.URL); err != nil {
+		t.Fatalf("Get before reload: %v", err)
+	}
+
+	// 服务端换成新 CA 签发的证书，同时信任新旧两个 CA
+	writeCerts(newCA.issue(t), oldCA, newCA)
+	if err := serverCerts.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tlsGet(t, newTestCertStore(t, oldCA.issue(t), oldCA.pool), srv.URL); err == nil {
+		t.Fatal("client that only trusts the old CA should reject the new server certificate")
+	}
+	if err := tlsGet(t, newTestCertStore(t, oldCA.issue(t), both), srv.URL); err != nil {
+		t.Fatalf("Get after reload: %v", err)
+	}
+
+	// 不合法的文件不影响当前证书
+	ioutil.WriteFile(certFile, []byte("garbage"), 0600)
+	if err := serverCerts.Reload(); err == nil {
+		t.Fatal("expect error reloading an invalid certificate")
+	}
+	if err := tlsGet(t, newTestCertStore(t, newCA.issue(t), both), srv.URL); err != nil {
+		t.Fatalf("Get after a failed reload: %v", err)
+	}
+}
@@ -23,6 +23,7 @@ type Header struct {
 	ServiceMethod string //服务名和方法名
 	Seq           uint64
 	Error         string
+	From          string //转发请求的节点，不为空时服务端只在本机处理，不再转发
 }
 
 type Codec interface {
@@ -30,16 +30,43 @@ func New(replicas int, fn Hash) *Map {
 // Add 添加真实节点/机器
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
-			//虚拟节点的哈希值
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key))) //对每一个真实节点 key，对应创建 m.replicas 个虚拟节点
-			m.keys = append(m.keys, hash)                      //添加到环上
-			m.hashMap[hash] = key
-		}
+		m.add(key, m.replicas)
 	}
 	sort.Ints(m.keys)
 }
 
+// AddWeighted 添加一个真实节点，虚拟节点数为 replicas*weight，权重越大分到的 key 越多
+func (m *Map) AddWeighted(key string, weight int) {
+	m.add(key, m.replicas*weight)
+	sort.Ints(m.keys)
+}
+
+func (m *Map) add(key string, replicas int) {
+	for i := 0; i < replicas; i++ {
+		//虚拟节点的哈希值
+		hash := int(m.hash([]byte(strconv.Itoa(i) + key))) //对每一个真实节点 key，对应创建 m.replicas 个虚拟节点
+		m.keys = append(m.keys, hash)                      //添加到环上
+		m.hashMap[hash] = key
+	}
+}
+
+// Remove 从环上删除真实节点及其所有虚拟节点
+func (m *Map) Remove(keys ...string) {
+	removed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		removed[key] = true
+	}
+	kept := m.keys[:0]
+	for _, hash := range m.keys {
+		if removed[m.hashMap[hash]] {
+			delete(m.hashMap, hash)
+			continue
+		}
+		kept = append(kept, hash)
+	}
+	m.keys = kept
+}
+
 func (m *Map) Get(key string) string {
 	if len(m.keys) == 0 {
 		return ""
@@ -51,3 +78,44 @@ func (m *Map) Get(key string) string {
 
 	return m.hashMap[m.keys[idx%len(m.keys)]] //idx = len(m.keys) 的时候 取环第一个节点
 }
+
+// GetN 从 key 的位置顺时针遍历哈希环，返回最多 n 个互不相同的真实节点，第一个即为 Get 的结果
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(m.keys) && len(nodes) < n; i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Shares 返回每个真实节点的虚拟节点数，以及它在哈希环上负责的哈希空间比例
+func (m *Map) Shares() (vnodes map[string]int, shares map[string]float64) {
+	vnodes = make(map[string]int)
+	shares = make(map[string]float64)
+	const space = float64(1 << 32)
+	for i, hash := range m.keys {
+		node := m.hashMap[hash]
+		vnodes[node]++
+		var prev int64
+		if i > 0 {
+			prev = int64(m.keys[i-1])
+		} else {
+			prev = int64(m.keys[len(m.keys)-1]) - 1<<32 //第一个虚拟节点同时负责环尾到 2^32 的部分
+		}
+		shares[node] += float64(int64(hash)-prev) / space
+	}
+	return vnodes, shares
+}
@@ -1,6 +1,7 @@
 package consistenthash
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -39,3 +40,73 @@ func TestHashing(t *testing.T) {
 		}
 	}
 }
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(data []byte) uint32 {
+		i, _ := strconv.Atoi(string(data))
+		return uint32(i)
+	})
+	hash.Add("6", "4", "2")
+
+	testCases := map[string][]string{
+		"2":  {"2", "4", "6"},
+		"11": {"2", "4", "6"},
+		"23": {"4", "6", "2"},
+		"27": {"2", "4", "6"},
+	}
+	for k, v := range testCases {
+		if got := hash.GetN(k, 5); !reflect.DeepEqual(got, v) {
+			t.Fatalf("GetN(%s) = %v, expect %v", k, got, v)
+		}
+		if got := hash.GetN(k, 1); got[0] != hash.Get(k) {
+			t.Fatalf("GetN(%s, 1) = %v, expect Get = %s", k, got, hash.Get(k))
+		}
+	}
+}
+
+func TestRemoveAndWeight(t *testing.T) {
+	hash := New(3, func(data []byte) uint32 {
+		i, _ := strconv.Atoi(string(data))
+		return uint32(i)
+	})
+	hash.Add("6", "4", "2")
+	hash.Remove("4")
+	// 4, 14, 24 被删除后 23 顺时针落到 26
+	if got := hash.Get("23"); got != "6" {
+		t.Fatalf("Get(23) = %s after removing 4, expect 6", got)
+	}
+	if len(hash.keys) != 6 || len(hash.hashMap) != 6 {
+		t.Fatalf("virtual nodes of 4 should be removed, keys = %v", hash.keys)
+	}
+
+	// 权重 2 时有 6 个虚拟节点: 8, 18, 28, 38, 48, 58
+	hash.AddWeighted("8", 2)
+	if got := hash.Get("50"); got != "8" {
+		t.Fatalf("Get(50) = %s, expect 8", got)
+	}
+}
+
+func TestShares(t *testing.T) {
+	hash := New(3, func(data []byte) uint32 {
+		i, _ := strconv.Atoi(string(data))
+		return uint32(i)
+	})
+	hash.Add("6", "4")
+	hash.AddWeighted("2", 2)
+
+	vnodes, shares := hash.Shares()
+	if !reflect.DeepEqual(vnodes, map[string]int{"2": 6, "4": 3, "6": 3}) {
+		t.Fatalf("vnodes = %v", vnodes)
+	}
+	// 4 负责 (2, 4]、(12, 14]、(22, 24]
+	if shares["4"] != 6/float64(1<<32) {
+		t.Fatalf("share of 4 = %v", shares["4"])
+	}
+	sum := 0.0
+	for _, s := range shares {
+		sum += s
+	}
+	if sum != 1 {
+		t.Fatalf("shares should add up to 1, got %v", sum)
+	}
+}
@@ -66,12 +66,33 @@ func (c *Cache) Get(key string) (Value, bool) {
 func (c *Cache) RemoveOldest() {
 	ele := c.ll.Back()
 	if ele != nil {
-		c.ll.Remove(ele)
+		c.removeElement(ele)
+	}
+}
+
+// Remove 删除指定 key，不存在时什么都不做
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	c.ll.Remove(ele)
+	kv := ele.Value.(*entry)
+	delete(c.cache, kv.key)
+	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	if c.OnEvicted != nil { //回调函数如果有的话 触发
+		c.OnEvicted(kv.key, kv.value)
+	}
+}
+
+// Range 从最久未访问到最近访问依次遍历所有记录，fn 返回 false 时停止；遍历不改变访问顺序
+func (c *Cache) Range(fn func(key string, value Value) bool) {
+	for ele := c.ll.Back(); ele != nil; ele = ele.Prev() {
 		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		if c.OnEvicted != nil { //回调函数如果有的话 触发
-			c.OnEvicted(kv.key, kv.value)
+		if !fn(kv.key, kv.value) {
+			return
 		}
 	}
 }
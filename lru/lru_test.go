@@ -54,3 +54,24 @@ func TestOnEvicted(t *testing.T) {
 		t.Logf("Call OnEvicted ok, expect key equals to %s", keys)
 	}
 }
+
+func TestRange(t *testing.T) {
+	lru := New(0, nil)
+	lru.Add("k1", String("1"))
+	lru.Add("k2", String("2"))
+	lru.Add("k3", String("3"))
+	lru.Get("k1") //k1 变为最近访问
+	lru.Remove("k2")
+
+	keys := make([]string, 0)
+	lru.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if expect := []string{"k3", "k1"}; !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Range got %v, expect %v", keys, expect)
+	}
+	if lru.nbytes != 6 {
+		t.Fatalf("nbytes = %d after Remove, expect 6", lru.nbytes)
+	}
+}
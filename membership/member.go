@@ -0,0 +1,77 @@
+package membership
+
+import "fmt"
+
+// State 是成员在本节点视角下的状态
+type State int
+
+const (
+	StateAlive State = iota
+	StateSuspect
+	StateDead
+	StateLeft
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	case StateLeft:
+		return "left"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Member 是集群中的一个节点
+type Member struct {
+	Name        string // gossip 地址 host:port，也是成员的唯一标识
+	Meta        string // 节点附带的业务数据，例如 cache 节点的 HTTP 地址
+	Incarnation uint64 // 由成员自己递增，用于反驳对它的怀疑
+	State       State
+}
+
+// live 表示成员仍在集群中：suspect 状态的成员在确认死亡前保留
+func (m Member) live() bool {
+	return m.State == StateAlive || m.State == StateSuspect
+}
+
+// overrides 判断更新 u 是否应该覆盖当前状态 cur，规则与 SWIM 一致：
+// incarnation 更大的消息优先；相同 incarnation 时 dead/left > suspect > alive
+func overrides(u, cur Member) bool {
+	if u.Incarnation != cur.Incarnation {
+		return u.Incarnation > cur.Incarnation
+	}
+	return rank(u.State) > rank(cur.State)
+}
+
+func rank(s State) int {
+	if s == StateLeft {
+		return int(StateDead)
+	}
+	return int(s)
+}
+
+// Metas 返回 members 中非空的 Meta，保持 members 的顺序。Meta 为空的成员只观察集群，不参与哈希环
+func Metas(members []Member) []string {
+	metas := make([]string, 0, len(members))
+	for _, m := range members {
+		if m.Meta != "" {
+			metas = append(metas, m.Meta)
+		}
+	}
+	return metas
+}
+
+// SetPeers 返回一个 OnChange 回调，每次成员变化时用各成员的 Meta 调用 set，
+// 通常传入 HTTPPool.Set，让哈希环跟随集群成员变化：
+//
+//	membership.Create(membership.Config{Meta: addr, OnChange: membership.SetPeers(pool.Set)})
+func SetPeers(set func(peers ...string)) func(members []Member) {
+	return func(members []Member) {
+		set(Metas(members)...)
+	}
+}
@@ -0,0 +1,72 @@
+package membership
+
+import "sort"
+
+type msgType int
+
+const (
+	msgPing msgType = iota
+	msgAck
+	msgPingReq
+)
+
+// message 是节点之间交换的 UDP 报文，使用 JSON 编码
+//
+//	ping:    From 请求 Target 回复 ack(Seq)；Join 为 true 时对方在 ack 中带上完整的成员列表
+//	ack:     对 ping 的回复
+//	pingReq: From 请求对方代为 ping Target，收到 Target 的 ack 后再以 ack(Seq) 回复 From
+//
+// 每个报文都可以捎带 Updates，成员状态的变化靠这种方式在集群中传播
+type message struct {
+	Type    msgType
+	Seq     uint64
+	From    string
+	Target  string `json:",omitempty"`
+	Join    bool   `json:",omitempty"`
+	Updates []Member
+}
+
+// broadcast 是等待捎带出去的成员状态更新
+type broadcast struct {
+	m         Member
+	transmits int
+}
+
+// broadcastQueue 按发送次数从少到多选出需要捎带的更新，每条更新发送 limit 次后丢弃
+type broadcastQueue struct {
+	items []*broadcast
+}
+
+// push 加入一条更新，同一成员旧的更新会被替换
+func (q *broadcastQueue) push(m Member) {
+	for i, b := range q.items {
+		if b.m.Name == m.Name {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+	q.items = append(q.items, &broadcast{m: m})
+}
+
+func (q *broadcastQueue) take(max, limit int) []Member {
+	sort.SliceStable(q.items, func(i, j int) bool {
+		return q.items[i].transmits < q.items[j].transmits
+	})
+	var out []Member
+	kept := q.items[:0]
+	for _, b := range q.items {
+		if len(out) < max {
+			out = append(out, b.m)
+			b.transmits++
+		}
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	q.items = kept
+	return out
+}
+
+func (q *broadcastQueue) len() int {
+	return len(q.items)
+}
@@ -0,0 +1,545 @@
+package membership
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProbeInterval    = time.Second
+	defaultProbeTimeout     = 300 * time.Millisecond
+	defaultIndirectChecks   = 3
+	defaultSuspicionTimeout = 5 * time.Second
+	defaultRetransmitMult   = 4
+	defaultJoinTimeout      = 2 * time.Second
+	defaultReclaimTimeout   = time.Minute
+
+	maxPiggyback  = 16
+	maxPacketSize = 64 * 1024
+)
+
+var ErrJoinFailed = errors.New("membership: failed to join any seed")
+
+// Config 是 Node 的配置，零值字段使用默认值
+type Config struct {
+	// BindAddr 监听的 UDP 地址，默认 "127.0.0.1:0"
+	BindAddr string
+	// AdvertiseAddr 其他节点访问本节点使用的地址，默认为实际监听的地址
+	AdvertiseAddr string
+	// Meta 随成员信息一起传播的数据，通常是 cache 节点的 HTTP 地址
+	Meta string
+
+	// ProbeInterval 每轮探测的间隔，默认 1s
+	ProbeInterval time.Duration
+	// ProbeTimeout 等待直接 ping 回复的时间，超时后发起间接 ping，默认 300ms
+	ProbeTimeout time.Duration
+	// IndirectChecks 间接 ping 时请求的节点数，默认 3
+	IndirectChecks int
+	// SuspicionTimeout 成员被怀疑后多久被确认为死亡，默认 5s
+	SuspicionTimeout time.Duration
+	// RetransmitMult 每条状态更新的捎带次数为 RetransmitMult*log10(n+1)，默认 4
+	RetransmitMult int
+	// JoinTimeout 等待种子节点回复的时间，默认 2s
+	JoinTimeout time.Duration
+	// ReclaimTimeout dead/left 成员的墓碑保留多久后被删除，默认 1min。
+	// 墓碑随加入回复发送给新成员，不删除会让报文随集群变动不断增大；该时间需远大于状态传播所需的时间
+	ReclaimTimeout time.Duration
+
+	// OnChange 在集群成员（alive 和 suspect）发生变化时被调用，参数按 Name 排序并包含本节点，
+	// 通常用 SetPeers(pool.Set) 以各成员的 Meta 更新 HTTPPool 的一致性哈希环
+	// 回调在单独的 goroutine 中按顺序执行，连续的变化可能被合并为一次回调
+	OnChange func(members []Member)
+}
+
+// Node 是 SWIM 协议的一个成员：周期性地 ping 一个成员，超时后通过其他成员间接 ping，
+// 仍然失败则把它标记为 suspect，suspect 超时后标记为 dead；状态变化捎带在报文中传播
+type Node struct {
+	conf Config
+	conn *net.UDPConn
+
+	mu         sync.Mutex
+	self       Member
+	members    map[string]*Member   //包括本节点以及 dead/left 的墓碑
+	tombstones map[string]time.Time //墓碑的创建时间，超过 ReclaimTimeout 后从 members 中删除
+	leaving    bool
+	seq        uint64
+	acks       map[uint64]chan struct{}
+	queue      broadcastQueue
+	suspicions map[string]*time.Timer
+	probeOrder []string
+	probeIdx   int
+
+	changed  chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// Create 创建节点并开始监听和探测，之后调用 Join 加入已有集群
+func Create(conf Config) (*Node, error) {
+	if conf.BindAddr == "" {
+		conf.BindAddr = "127.0.0.1:0"
+	}
+	if conf.ProbeInterval <= 0 {
+		conf.ProbeInterval = defaultProbeInterval
+	}
+	if conf.ProbeTimeout <= 0 || conf.ProbeTimeout >= conf.ProbeInterval {
+		conf.ProbeTimeout = defaultProbeTimeout
+		if conf.ProbeTimeout >= conf.ProbeInterval {
+			conf.ProbeTimeout = conf.ProbeInterval / 3
+		}
+	}
+	if conf.IndirectChecks <= 0 {
+		conf.IndirectChecks = defaultIndirectChecks
+	}
+	if conf.SuspicionTimeout <= 0 {
+		conf.SuspicionTimeout = defaultSuspicionTimeout
+	}
+	if conf.RetransmitMult <= 0 {
+		conf.RetransmitMult = defaultRetransmitMult
+	}
+	if conf.JoinTimeout <= 0 {
+		conf.JoinTimeout = defaultJoinTimeout
+	}
+	if conf.ReclaimTimeout <= 0 {
+		conf.ReclaimTimeout = defaultReclaimTimeout
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", conf.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if conf.AdvertiseAddr == "" {
+		conf.AdvertiseAddr = conn.LocalAddr().String()
+	}
+
+	n := &Node{
+		conf:       conf,
+		conn:       conn,
+		self:       Member{Name: conf.AdvertiseAddr, Meta: conf.Meta, State: StateAlive},
+		members:    make(map[string]*Member),
+		tombstones: make(map[string]time.Time),
+		acks:       make(map[uint64]chan struct{}),
+		suspicions: make(map[string]*time.Timer),
+		changed:    make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	self := n.self
+	n.members[self.Name] = &self
+	n.notify()
+
+	n.wg.Add(3)
+	go n.readLoop()
+	go n.probeLoop()
+	go n.notifyLoop()
+	return n, nil
+}
+
+// Name 返回本节点的 gossip 地址
+func (n *Node) Name() string {
+	return n.conf.AdvertiseAddr
+}
+
+// Members 返回集群中 alive 和 suspect 状态的成员，按 Name 排序
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.liveMembers()
+}
+
+func (n *Node) liveMembers() []Member {
+	members := make([]Member, 0, len(n.members))
+	for _, m := range n.members {
+		if m.live() {
+			members = append(members, *m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name < members[j].Name
+	})
+	return members
+}
+
+// Join 通过任意一个种子节点加入集群，至少一个种子回复了完整的成员列表即为成功
+func (n *Node) Join(seeds ...string) error {
+	joined := 0
+	for _, seed := range seeds {
+		if seed == n.Name() {
+			continue
+		}
+		seq, ch := n.expectAck()
+		n.mu.Lock()
+		self := n.self
+		n.mu.Unlock()
+		if err := n.send(seed, message{Type: msgPing, Seq: seq, Target: seed, Join: true, Updates: []Member{self}}); err != nil {
+			log.Printf("[membership] %s join %s fail, the err: %v \n", n.Name(), seed, err)
+			n.forgetAck(seq)
+			continue
+		}
+		select {
+		case <-ch:
+			joined++
+		case <-time.After(n.conf.JoinTimeout):
+			log.Printf("[membership] %s join %s timeout \n", n.Name(), seed)
+		case <-n.done:
+			return ErrJoinFailed
+		}
+		n.forgetAck(seq)
+	}
+	if joined == 0 && len(seeds) > 0 {
+		return ErrJoinFailed
+	}
+	return nil
+}
+
+// Leave 通知其他成员本节点主动离开，然后关闭节点
+func (n *Node) Leave() error {
+	n.mu.Lock()
+	n.leaving = true
+	n.self.Incarnation++
+	n.self.State = StateLeft
+	self := n.self
+	n.members[self.Name] = &self
+	var targets []string
+	for _, m := range n.members {
+		if m.live() {
+			targets = append(targets, m.Name)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, target := range targets {
+		n.send(target, message{Type: msgPing, Seq: n.nextSeq(), Target: target, Updates: []Member{self}})
+	}
+	return n.Shutdown()
+}
+
+// Shutdown 直接停止节点，其他成员会通过探测发现它已失效
+func (n *Node) Shutdown() error {
+	var err error
+	n.stopOnce.Do(func() {
+		close(n.done)
+		err = n.conn.Close()
+		n.wg.Wait()
+		n.mu.Lock()
+		for _, t := range n.suspicions {
+			t.Stop()
+		}
+		n.mu.Unlock()
+	})
+	return err
+}
+
+func (n *Node) nextSeq() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	return n.seq
+}
+
+func (n *Node) expectAck() (uint64, chan struct{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	ch := make(chan struct{}, 1)
+	n.acks[n.seq] = ch
+	return n.seq, ch
+}
+
+func (n *Node) forgetAck(seq uint64) {
+	n.mu.Lock()
+	delete(n.acks, seq)
+	n.mu.Unlock()
+}
+
+// send 发送报文并捎带待传播的状态更新
+func (n *Node) send(to string, msg message) error {
+	n.mu.Lock()
+	msg.From = n.self.Name
+	limit := n.conf.RetransmitMult * int(math.Ceil(math.Log10(float64(len(n.members)+1))))
+	msg.Updates = append(msg.Updates, n.queue.take(maxPiggyback, limit)...)
+	n.mu.Unlock()
+
+	addr, err := net.ResolveUDPAddr("udp", to)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(&msg)
+	if err != nil {
+		return err
+	}
+	_, err = n.conn.WriteToUDP(b, addr)
+	return err
+}
+
+func (n *Node) readLoop() {
+	defer n.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		size, _, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-n.done:
+				return
+			default:
+			}
+			log.Printf("[membership] %s read error: %v \n", n.Name(), err)
+			continue
+		}
+		var msg message
+		if err := json.Unmarshal(buf[:size], &msg); err != nil {
+			log.Printf("[membership] %s invalid packet: %v \n", n.Name(), err)
+			continue
+		}
+		n.handle(&msg)
+	}
+}
+
+func (n *Node) handle(msg *message) {
+	for _, u := range msg.Updates {
+		n.apply(u)
+	}
+	switch msg.Type {
+	case msgPing:
+		if msg.Target != "" && msg.Target != n.Name() {
+			return
+		}
+		ack := message{Type: msgAck, Seq: msg.Seq}
+		if msg.Join {
+			n.mu.Lock()
+			for _, m := range n.members {
+				ack.Updates = append(ack.Updates, *m)
+			}
+			n.mu.Unlock()
+		}
+		n.send(msg.From, ack)
+	case msgAck:
+		n.mu.Lock()
+		ch, ok := n.acks[msg.Seq]
+		n.mu.Unlock()
+		if ok {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	case msgPingReq:
+		go n.indirectPing(msg.From, msg.Seq, msg.Target)
+	}
+}
+
+// indirectPing 代 from ping target，收到回复后以 from 的 seq 回复 from
+func (n *Node) indirectPing(from string, fromSeq uint64, target string) {
+	seq, ch := n.expectAck()
+	defer n.forgetAck(seq)
+	if err := n.send(target, message{Type: msgPing, Seq: seq, Target: target}); err != nil {
+		return
+	}
+	select {
+	case <-ch:
+		n.send(from, message{Type: msgAck, Seq: fromSeq})
+	case <-time.After(n.conf.ProbeTimeout):
+	case <-n.done:
+	}
+}
+
+// apply 应用一条成员状态更新，并在状态发生变化时继续传播它
+func (n *Node) apply(u Member) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if u.Name == n.self.Name {
+		// 有人认为本节点 suspect/dead，递增 incarnation 反驳
+		if !n.leaving && u.State != StateAlive && u.Incarnation >= n.self.Incarnation {
+			n.self.Incarnation = u.Incarnation + 1
+			self := n.self
+			n.members[self.Name] = &self
+			n.queue.push(self)
+			log.Printf("[membership] %s refute %s with incarnation %d \n", n.self.Name, u.State, n.self.Incarnation)
+		}
+		return
+	}
+
+	cur, ok := n.members[u.Name]
+	if ok && !overrides(u, *cur) {
+		return
+	}
+	if (!ok || !cur.live()) && u.State == StateSuspect { //不根据 suspect 消息复活或加入成员
+		return
+	}
+	wasLive := ok && cur.live()
+	m := u
+	n.members[u.Name] = &m
+	n.queue.push(m)
+
+	if t, ok := n.suspicions[u.Name]; ok {
+		t.Stop()
+		delete(n.suspicions, u.Name)
+	}
+	if u.State == StateSuspect {
+		n.suspicions[u.Name] = time.AfterFunc(n.conf.SuspicionTimeout, func() {
+			n.confirmDead(u)
+		})
+	}
+	if m.live() {
+		delete(n.tombstones, u.Name)
+	} else if _, ok := n.tombstones[u.Name]; !ok {
+		n.tombstones[u.Name] = time.Now()
+	}
+	if wasLive != m.live() {
+		log.Printf("[membership] %s sees %s %s \n", n.self.Name, u.Name, u.State)
+		n.notify()
+	}
+}
+
+// suspect 把探测失败的成员标记为 suspect
+func (n *Node) suspect(m Member) {
+	m.State = StateSuspect
+	n.apply(m)
+}
+
+// confirmDead 在 suspicion 超时且成员没有反驳时把它标记为 dead
+func (n *Node) confirmDead(suspected Member) {
+	n.mu.Lock()
+	cur, ok := n.members[suspected.Name]
+	still := ok && cur.State == StateSuspect && cur.Incarnation == suspected.Incarnation
+	n.mu.Unlock()
+	if still {
+		suspected.State = StateDead
+		n.apply(suspected)
+	}
+}
+
+func (n *Node) probeLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.conf.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case now := <-ticker.C:
+			n.reclaim(now)
+			n.probe()
+		}
+	}
+}
+
+// reclaim 删除保留超过 ReclaimTimeout 的墓碑
+func (n *Node) reclaim(now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for name, since := range n.tombstones {
+		if now.Sub(since) >= n.conf.ReclaimTimeout {
+			delete(n.tombstones, name)
+			delete(n.members, name)
+		}
+	}
+}
+
+// nextTarget 按随机顺序轮流选择被探测的成员，每轮结束后重新打乱
+func (n *Node) nextTarget() (Member, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for tries := 0; tries < 2; tries++ {
+		for n.probeIdx < len(n.probeOrder) {
+			name := n.probeOrder[n.probeIdx]
+			n.probeIdx++
+			if m, ok := n.members[name]; ok && m.live() && name != n.self.Name {
+				return *m, true
+			}
+		}
+		n.probeOrder = n.probeOrder[:0]
+		for name := range n.members {
+			n.probeOrder = append(n.probeOrder, name)
+		}
+		rand.Shuffle(len(n.probeOrder), func(i, j int) {
+			n.probeOrder[i], n.probeOrder[j] = n.probeOrder[j], n.probeOrder[i]
+		})
+		n.probeIdx = 0
+	}
+	return Member{}, false
+}
+
+func (n *Node) probe() {
+	target, ok := n.nextTarget()
+	if !ok {
+		return
+	}
+	seq, ch := n.expectAck()
+	defer n.forgetAck(seq)
+	if err := n.send(target.Name, message{Type: msgPing, Seq: seq, Target: target.Name}); err != nil {
+		log.Printf("[membership] %s ping %s fail, the err: %v \n", n.Name(), target.Name, err)
+	}
+	select {
+	case <-ch:
+		return
+	case <-time.After(n.conf.ProbeTimeout):
+	case <-n.done:
+		return
+	}
+
+	for _, helper := range n.randomMembers(n.conf.IndirectChecks, target.Name) {
+		n.send(helper, message{Type: msgPingReq, Seq: seq, Target: target.Name})
+	}
+	select {
+	case <-ch:
+		return
+	case <-time.After(n.conf.ProbeInterval - n.conf.ProbeTimeout):
+	case <-n.done:
+		return
+	}
+	log.Printf("[membership] %s suspect %s \n", n.Name(), target.Name)
+	n.suspect(target)
+}
+
+// randomMembers 随机选择最多 k 个除本节点和 exclude 以外的 alive 成员
+func (n *Node) randomMembers(k int, exclude string) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var names []string
+	for name, m := range n.members {
+		if m.State == StateAlive && name != n.self.Name && name != exclude {
+			names = append(names, name)
+		}
+	}
+	rand.Shuffle(len(names), func(i, j int) {
+		names[i], names[j] = names[j], names[i]
+	})
+	if len(names) > k {
+		names = names[:k]
+	}
+	return names
+}
+
+func (n *Node) notify() {
+	select {
+	case n.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) notifyLoop() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-n.changed:
+			if n.conf.OnChange != nil {
+				n.conf.OnChange(n.Members())
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package membership
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testCluster struct {
+	mu    sync.Mutex
+	rings map[string][]string //每个节点最近一次 OnChange 得到的 Meta 列表，相当于 HTTPPool.Set 的参数
+	nodes []*Node
+}
+
+func newTestCluster(t *testing.T, n int) *testCluster {
+	c := &testCluster{rings: make(map[string][]string)}
+	for i := 0; i < n; i++ {
+		meta := fmt.Sprintf("http://cache-%d", i)
+		node, err := Create(Config{
+			Meta:             meta,
+			ProbeInterval:    30 * time.Millisecond,
+			ProbeTimeout:     10 * time.Millisecond,
+			SuspicionTimeout: 100 * time.Millisecond,
+			OnChange: func(members []Member) {
+				peers := make([]string, 0, len(members))
+				for _, m := range members {
+					peers = append(peers, m.Meta)
+				}
+				sort.Strings(peers)
+				c.mu.Lock()
+				c.rings[meta] = peers
+				c.mu.Unlock()
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.nodes = append(c.nodes, node)
+	}
+	t.Cleanup(func() {
+		for _, node := range c.nodes {
+			node.Shutdown()
+		}
+	})
+	return c
+}
+
+// waitRing 等待 nodes 中每个节点的 OnChange 都收到 expect
+func (c *testCluster) waitRing(t *testing.T, nodes []int, expect ...int) {
+	want := make([]string, 0, len(expect))
+	for _, i := range expect {
+		want = append(want, fmt.Sprintf("http://cache-%d", i))
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for _, i := range nodes {
+		meta := fmt.Sprintf("http://cache-%d", i)
+		for {
+			c.mu.Lock()
+			got := fmt.Sprint(c.rings[meta])
+			c.mu.Unlock()
+			if got == fmt.Sprint(want) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("node %d sees %s, expect %v", i, got, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+func TestJoinLeaveAndFailure(t *testing.T) {
+	c := newTestCluster(t, 4)
+	seed := c.nodes[0].Name()
+	for _, node := range c.nodes[1:] {
+		if err := node.Join(seed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.waitRing(t, []int{0, 1, 2, 3}, 0, 1, 2, 3)
+
+	// 主动离开
+	if err := c.nodes[3].Leave(); err != nil {
+		t.Fatal(err)
+	}
+	c.waitRing(t, []int{0, 1, 2}, 0, 1, 2)
+
+	// 直接宕机，经过 suspect 后被确认死亡
+	c.nodes[2].Shutdown()
+	c.waitRing(t, []int{0, 1}, 0, 1)
+	for _, m := range c.nodes[0].Members() {
+		if m.Name == c.nodes[2].Name() {
+			t.Fatalf("failed node should be removed, got %+v", m)
+		}
+	}
+}
+
+func TestRefuteSuspicion(t *testing.T) {
+	c := newTestCluster(t, 2)
+	if err := c.nodes[1].Join(c.nodes[0].Name()); err != nil {
+		t.Fatal(err)
+	}
+	c.waitRing(t, []int{0, 1}, 0, 1)
+
+	// 错误地怀疑 node1，node1 收到后递增 incarnation 反驳，不会被确认死亡
+	victim := c.nodes[1].Name()
+	c.nodes[0].mu.Lock()
+	m := *c.nodes[0].members[victim]
+	c.nodes[0].mu.Unlock()
+	c.nodes[0].suspect(m)
+
+	time.Sleep(200 * time.Millisecond)
+	c.waitRing(t, []int{0}, 0, 1)
+	c.nodes[0].mu.Lock()
+	cur := *c.nodes[0].members[victim]
+	c.nodes[0].mu.Unlock()
+	if cur.State != StateAlive || cur.Incarnation == 0 {
+		t.Fatalf("suspicion should be refuted, got %+v", cur)
+	}
+}
+
+func TestJoinFailed(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.nodes[0].conf.JoinTimeout = 20 * time.Millisecond
+	if err := c.nodes[0].Join("127.0.0.1:1"); err != ErrJoinFailed {
+		t.Fatalf("expect ErrJoinFailed, got %v", err)
+	}
+}
+
+func TestReclaimTombstones(t *testing.T) {
+	c := newTestCluster(t, 3)
+	for _, node := range c.nodes[1:] {
+		if err := node.Join(c.nodes[0].Name()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.waitRing(t, []int{0, 1, 2}, 0, 1, 2)
+	c.nodes[2].Shutdown()
+	c.waitRing(t, []int{0}, 0, 1)
+
+	dead := c.nodes[2].Name()
+	node := c.nodes[0]
+	node.mu.Lock()
+	since, ok := node.tombstones[dead]
+	node.mu.Unlock()
+	if !ok {
+		t.Fatal("dead member should be kept as a tombstone")
+	}
+	node.reclaim(since.Add(node.conf.ReclaimTimeout - time.Millisecond))
+	node.mu.Lock()
+	_, ok = node.members[dead]
+	node.mu.Unlock()
+	if !ok {
+		t.Fatal("tombstone should be kept until ReclaimTimeout")
+	}
+
+	// 超时后墓碑被删除，不再随加入回复发送
+	node.reclaim(since.Add(node.conf.ReclaimTimeout))
+	node.mu.Lock()
+	_, ok = node.members[dead]
+	_, tracked := node.tombstones[dead]
+	live := len(node.members)
+	node.mu.Unlock()
+	if ok || tracked || live != 2 {
+		t.Fatalf("tombstone should be reclaimed, members = %d", live)
+	}
+}